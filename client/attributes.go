@@ -0,0 +1,320 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// encodedAttribute is a common attribute ready to be merged into events
+type encodedAttribute struct {
+	key      []byte // the quoted name, as it appears in JSON
+	fragment []byte // "name":value
+}
+
+// commonAttributes returns the encoded attributes of the MetadataProviders,
+// CommonAttributes and CommonAttributesFunc, each winning over the previous
+// ones on conflicts. The static ones are worked out once.
+func (c *InsertClient) commonAttributes() ([]encodedAttribute, error) {
+	c.commonOnce.Do(func() {
+		static := c.metadata()
+		if static == nil {
+			static = c.CommonAttributes
+		} else {
+			for name, value := range c.CommonAttributes {
+				static[name] = value
+			}
+		}
+		c.commonEncoded, c.commonErr = encodeAttributes(static)
+	})
+	if c.commonErr != nil {
+		return nil, c.commonErr
+	}
+	if c.CommonAttributesFunc == nil {
+		return c.commonEncoded, nil
+	}
+
+	dynamic, err := encodeAttributes(c.CommonAttributesFunc())
+	if err != nil {
+		return nil, err
+	}
+	if len(c.commonEncoded) == 0 {
+		return dynamic, nil
+	}
+
+	// mergeAttributes keeps the first of duplicate names
+	return append(dynamic, c.commonEncoded...), nil
+}
+
+// encodeAttributes encodes attributes in name order, refusing values
+// Insights does not support
+func encodeAttributes(attrs map[string]interface{}) ([]encodedAttribute, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	encoded := make([]encodedAttribute, 0, len(attrs))
+	for _, name := range names {
+		if rule, msg := checkAttributeName(name); rule != "" || name == "eventType" {
+			if msg == "" {
+				msg = "eventType can't be a common attribute"
+			}
+			return nil, fmt.Errorf("invalid common attribute: %s", msg)
+		}
+
+		value, err := encodeAttributeValue(attrs[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid common attribute %s: %w", name, err)
+		}
+
+		key := appendJSONString(nil, name)
+		fragment := make([]byte, 0, len(key)+1+len(value))
+		fragment = append(append(append(fragment, key...), ':'), value...)
+		encoded = append(encoded, encodedAttribute{key: key, fragment: fragment})
+	}
+
+	return encoded, nil
+}
+
+// encodeAttributeValue encodes a string, boolean, number or time.Time
+func encodeAttributeValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		if len(v) > MaxStringValueLength {
+			return nil, fmt.Errorf("value is %d bytes, longer than %d", len(v), MaxStringValueLength)
+		}
+		return appendJSONString(nil, v), nil
+	case bool:
+		return strconv.AppendBool(nil, v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return json.Marshal(v)
+	case float32:
+		return encodeAttributeValue(float64(v))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("value %v is not a supported number", v)
+		}
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	case time.Time:
+		return strconv.AppendInt(nil, v.UnixNano()/int64(time.Millisecond), 10), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// addCommonAttributes merges the common attributes into a marshalled event,
+// or into every event of a JSON array, without overriding any attribute
+// the event already has.
+func (c *InsertClient) addCommonAttributes(jsonData []byte) ([]byte, error) {
+	if len(c.CommonAttributes) == 0 && c.CommonAttributesFunc == nil && len(c.MetadataProviders) == 0 {
+		return jsonData, nil
+	}
+
+	attrs, err := c.commonAttributes()
+	if err != nil || len(attrs) == 0 {
+		return jsonData, err
+	}
+
+	start := skipJSONSpace(jsonData, 0)
+	if start >= len(jsonData) || jsonData[start] != '[' {
+		return mergeAttributes(jsonData, attrs), nil
+	}
+
+	// Merge into every object of the array
+	merged := make([]byte, 0, len(jsonData)+len(jsonData)/2)
+	merged = append(merged, jsonData[:start+1]...)
+	i := start + 1
+	for {
+		next := skipJSONSpace(jsonData, i)
+		if next >= len(jsonData) || jsonData[next] == ']' {
+			break
+		}
+		if jsonData[next] == ',' {
+			merged = append(merged, jsonData[i:next+1]...)
+			i = next + 1
+			continue
+		}
+
+		end := skipJSONValue(jsonData, next)
+		merged = append(merged, jsonData[i:next]...)
+		merged = append(merged, mergeAttributes(jsonData[next:end], attrs)...)
+		i = end
+	}
+	return append(merged, jsonData[i:]...), nil
+}
+
+// arrayElements returns the elements of a JSON array, or false if data is
+// not an array
+func arrayElements(data []byte) ([][]byte, bool) {
+	start := skipJSONSpace(data, 0)
+	if start >= len(data) || data[start] != '[' {
+		return nil, false
+	}
+
+	var elements [][]byte
+	i := start + 1
+	for {
+		i = skipJSONSpace(data, i)
+		if i >= len(data) || data[i] == ']' {
+			return elements, true
+		}
+		if data[i] == ',' {
+			i++
+			continue
+		}
+
+		end := skipJSONValue(data, i)
+		if end == i { // not a value, e.g. a stray '}'
+			return elements, true
+		}
+		elements = append(elements, data[i:end])
+		i = end
+	}
+}
+
+// mergeAttributes adds the attributes missing from a JSON object. Anything
+// other than an object is returned as is, for validation to refuse.
+func mergeAttributes(object []byte, attrs []encodedAttribute) []byte {
+	start := skipJSONSpace(object, 0)
+	end := bytes.LastIndexByte(object, '}')
+	if start >= len(object) || object[start] != '{' || end < start {
+		return object
+	}
+
+	keys := objectKeys(object[start:end])
+	hasAttrs := len(keys) > 0
+
+	merged := make([]byte, 0, len(object)+len(attrs)*32)
+	merged = append(merged, object[:end]...)
+	for i, attr := range attrs {
+		if containsKey(keys, attr.key) || containsAttribute(attrs[:i], attr.key) {
+			continue
+		}
+		if hasAttrs {
+			merged = append(merged, ',')
+		}
+		merged = append(merged, attr.fragment...)
+		hasAttrs = true
+	}
+	return append(merged, object[end:]...)
+}
+
+// objectKeys returns the quoted top-level keys of a JSON object, which
+// starts with '{' and may be missing its closing brace
+func objectKeys(object []byte) [][]byte {
+	var keys [][]byte
+
+	i := 1
+	for {
+		i = skipJSONSpace(object, i)
+		if i >= len(object) || object[i] != '"' {
+			return keys
+		}
+
+		keyEnd := skipJSONValue(object, i)
+		keys = append(keys, object[i:keyEnd])
+
+		i = skipJSONSpace(object, keyEnd)
+		if i >= len(object) || object[i] != ':' {
+			return keys
+		}
+		i = skipJSONValue(object, skipJSONSpace(object, i+1))
+
+		i = skipJSONSpace(object, i)
+		if i >= len(object) || object[i] != ',' {
+			return keys
+		}
+		i++
+	}
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAttribute(attrs []encodedAttribute, key []byte) bool {
+	for _, attr := range attrs {
+		if bytes.Equal(attr.key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipJSONSpace returns the index of the first non-whitespace byte from i
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipJSONValue returns the index just past the JSON value starting at i.
+// It assumes the JSON is well formed.
+func skipJSONValue(data []byte, i int) int {
+	if i >= len(data) {
+		return i
+	}
+
+	switch data[i] {
+	case '"':
+		for i++; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1
+			}
+		}
+		return i
+
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				i = skipJSONValue(data, i)
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return i
+
+	default:
+		for i < len(data) {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return i
+			}
+			i++
+		}
+		return i
+	}
+}
@@ -0,0 +1,126 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCommonAttributes(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.CommonAttributes = map[string]interface{}{
+		"host":    "web-1",
+		"env":     "prod",
+		"version": 3,
+	}
+	client.CommonAttributesFunc = func() map[string]interface{} {
+		return map[string]interface{}{"env": "canary", "up": true}
+	}
+
+	merged, err := client.addCommonAttributes([]byte(`{"eventType":"test","host":"override","nested":{"env":"x"},"s":"a\"}"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"eventType":"test","host":"override","nested":{"env":"x"},"s":"a\"}","env":"canary","up":true,"version":3}`, string(merged),
+		"Event values win over common ones, dynamic over static")
+
+	merged, err = client.addCommonAttributes([]byte(` [ {"eventType":"a"}, {"eventType":"b","up":false} ] `))
+	assert.NoError(t, err)
+	var events []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(merged, &events))
+	assert.Equal(t, []map[string]interface{}{
+		{"eventType": "a", "host": "web-1", "env": "canary", "version": float64(3), "up": true},
+		{"eventType": "b", "host": "web-1", "env": "canary", "version": float64(3), "up": false},
+	}, events)
+
+	merged, err = client.addCommonAttributes([]byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"env":"canary","up":true,"host":"web-1","version":3}`, string(merged))
+}
+
+func TestAddCommonAttributes_invalid(t *testing.T) {
+	for _, attrs := range []map[string]interface{}{
+		{"nested": map[string]interface{}{}},
+		{"eventType": "test"},
+		{"accountId": 1},
+	} {
+		client := NewInsertClient(testKey, testID)
+		client.CommonAttributes = attrs
+		_, err := client.marshalEvent(NewEvent("test"))
+		assert.Error(t, err, "%v", attrs)
+	}
+}
+
+func TestInsertCommonAttributes(t *testing.T) {
+	var err error
+	received := make(chan []map[string]interface{}, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		received <- events
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	at := time.Unix(1500000000, 0)
+	client.CommonAttributes = map[string]interface{}{"service": "checkout", "deployedAt": at}
+
+	// PostEvent
+	assert.NoError(t, client.PostEvent(`[{"eventType":"test"}]`))
+	assert.Equal(t, []map[string]interface{}{{"eventType": "test", "service": "checkout", "deployedAt": float64(1500000000000)}}, <-received)
+
+	// Batch mode
+	assert.NoError(t, client.Start())
+	assert.NoError(t, client.EnqueueEvent(NewEvent("test").SetString("service", "cart")))
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test"}))
+	assert.NoError(t, client.Close(context.Background()))
+	assert.Equal(t, []map[string]interface{}{
+		{"eventType": "test", "service": "cart", "deployedAt": float64(1500000000000)},
+		{"eventType": "test", "service": "checkout", "deployedAt": float64(1500000000000)},
+	}, <-received)
+}
+
+func BenchmarkAddCommonAttributes(b *testing.B) {
+	client := NewInsertClient(testKey, testID)
+	client.CommonAttributes = map[string]interface{}{"host": "web-1", "env": "prod", "service": "checkout", "version": "1.2.3"}
+	event := []byte(`{"eventType":"test","num":1,"str":"test","nested":"{\"a\":1}","host":"web-2"}`)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.addCommonAttributes(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestArrayElements(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		elements []string
+		isArray  bool
+	}{
+		{`{"a":1}`, nil, false},
+		{` [ ] `, nil, true},
+		{`[{"a":1}]`, []string{`{"a":1}`}, true},
+		{` [ {"a":"]"} , {"b":[1,2]},3 ]`, []string{`{"a":"]"}`, `{"b":[1,2]}`, `3`}, true},
+		{`[}]`, nil, true},
+	} {
+		elements, isArray := arrayElements([]byte(tc.data))
+		assert.Equal(t, tc.isArray, isArray, tc.data)
+
+		var got []string
+		for _, e := range elements {
+			got = append(got, string(e))
+		}
+		assert.Equal(t, tc.elements, got, tc.data)
+	}
+}
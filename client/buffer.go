@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Events count against MaxBufferedBytes from the time they are queued by
+// EnqueueEvent until the batch holding them has been sent, has failed, or
+// has been spooled. Statistics.BufferedBytes is the running total, and
+// waiters are woken by closing bufferFreed whenever bytes are released.
+
+// reserveBytes accounts for n more buffered bytes, failing if that would
+// go over MaxBufferedBytes.
+func (c *InsertClient) reserveBytes(n int64) bool {
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+
+	if c.MaxBufferedBytes > 0 && c.Statistics.BufferedBytes+n > c.MaxBufferedBytes && c.Statistics.BufferedBytes > 0 {
+		return false
+	}
+	atomic.AddInt64(&c.Statistics.BufferedBytes, n)
+	return true
+}
+
+// waitForBytes reserves n buffered bytes, waiting for others to be released
+// if needed.
+func (c *InsertClient) waitForBytes(ctx context.Context, quit chan struct{}, n int64) error {
+	for {
+		c.bufferMu.Lock()
+		if c.bufferFreed == nil {
+			c.bufferFreed = make(chan struct{})
+		}
+		freed := c.bufferFreed
+		c.bufferMu.Unlock()
+
+		if c.reserveBytes(n) {
+			return nil
+		}
+
+		select {
+		case <-freed:
+		case <-quit:
+			return ErrClientClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// releaseBytes gives back buffered bytes, waking up everyone waiting for them
+func (c *InsertClient) releaseBytes(n int64) {
+	if n == 0 {
+		return
+	}
+
+	c.bufferMu.Lock()
+	defer c.bufferMu.Unlock()
+
+	atomic.AddInt64(&c.Statistics.BufferedBytes, -n)
+	if c.bufferFreed != nil {
+		close(c.bufferFreed)
+		c.bufferFreed = nil
+	}
+}
+
+// batchBytes is the number of buffered bytes held by a batch of events
+func batchBytes(events [][]byte) int64 {
+	var n int64
+	for _, event := range events {
+		n += int64(len(event))
+	}
+	return n
+}
+
+// requestFlush asks the batch worker to send the batch being built, unless
+// a flush is already pending.
+func (c *InsertClient) requestFlush() {
+	c.stateMu.RLock()
+	flushQueue := c.flushQueue
+	c.stateMu.RUnlock()
+
+	select {
+	case flushQueue <- nil:
+	default:
+	}
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker guarding the insert
+// endpoint, see InsertClient.CircuitBreakerThreshold
+type CircuitState int32
+
+const (
+	// CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every request with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through, which decides
+	// whether the circuit closes or opens again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker stops sending requests to Insights after consecutive
+// failures, until a probe request succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	onChange  func(from, to CircuitState)
+	stats     *Statistics
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a request may be sent, returning ErrCircuitOpen if
+// not, and whether it is the probe request. Every allowed request must be
+// followed by a call to done, or to release if it was never sent.
+func (b *circuitBreaker) allow(now time.Time) (bool, error) {
+	b.mu.Lock()
+	from := b.state
+
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			atomic.AddInt64(&b.stats.CircuitRejectedCount, 1)
+			return false, ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		b.probing = true
+	case CircuitHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			atomic.AddInt64(&b.stats.CircuitRejectedCount, 1)
+			return false, ErrCircuitOpen
+		}
+		b.probing = true
+	}
+
+	to, probe := b.state, b.probing
+	b.mu.Unlock()
+	b.notify(from, to)
+	return probe, nil
+}
+
+// done records the outcome of an allowed request. Only server and network
+// errors count as failures; a request cancelled by its caller says nothing
+// about Insights.
+func (b *circuitBreaker) done(now time.Time, probe bool, err error) {
+	b.mu.Lock()
+	from := b.state
+	if probe {
+		b.probing = false
+	}
+
+	// Once the circuit has opened, only the probe decides what happens next
+	decides := probe || b.state == CircuitClosed
+
+	switch {
+	case errors.Is(err, context.Canceled), !decides:
+	case err != nil && retryable(err):
+		b.failures++
+		if probe || b.failures >= b.threshold {
+			b.setState(CircuitOpen)
+			b.openedAt = now
+		}
+	default:
+		b.failures = 0
+		b.setState(CircuitClosed)
+	}
+
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// release gives up on an allowed request before it was sent, without
+// counting it either way. A probe being released lets the next request
+// probe instead.
+func (b *circuitBreaker) release(probe bool) {
+	if !probe {
+		return
+	}
+
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// setState changes the state, recording it in the Statistics. b.mu must be
+// held.
+func (b *circuitBreaker) setState(state CircuitState) {
+	if state == b.state {
+		return
+	}
+
+	b.state = state
+	atomic.StoreInt64(&b.stats.CircuitStateValue, int64(state))
+	if state == CircuitOpen {
+		atomic.AddInt64(&b.stats.CircuitOpenCount, 1)
+	}
+}
+
+// notify reports a state change to onChange
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
+
+// circuitBreaker returns the circuit breaker built from the configuration,
+// or nil if it is disabled
+func (c *InsertClient) circuitBreaker() *circuitBreaker {
+	c.breakerOnce.Do(func() {
+		if c.CircuitBreakerThreshold <= 0 {
+			return
+		}
+		c.breaker = &circuitBreaker{
+			threshold: c.CircuitBreakerThreshold,
+			cooldown:  c.CircuitBreakerCooldown,
+			onChange:  c.OnCircuitStateChange,
+			stats:     &c.Statistics,
+		}
+	})
+	return c.breaker
+}
+
+// CircuitState returns the current state of the circuit breaker, which is
+// always CircuitClosed when it is disabled
+func (c *InsertClient) CircuitState() CircuitState {
+	return CircuitState(atomic.LoadInt64(&c.Statistics.CircuitStateValue))
+}
+
+// spoolable reports whether a batch that failed with err may still be
+// delivered later: Insights was unavailable, or the circuit breaker is open.
+func spoolable(err error) bool {
+	return retryable(err) || errors.Is(err, ErrCircuitOpen)
+}
@@ -0,0 +1,140 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []string
+	stats := &Statistics{}
+	breaker := &circuitBreaker{
+		threshold: 2,
+		cooldown:  time.Second,
+		stats:     stats,
+		onChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}
+	serverErr := &APIError{StatusCode: http.StatusServiceUnavailable}
+	now := time.Now()
+
+	// Client errors mean Insights is up
+	probe, err := breaker.allow(now)
+	assert.NoError(t, err)
+	assert.False(t, probe)
+	breaker.done(now, probe, serverErr)
+	breaker.done(now, false, &APIError{StatusCode: http.StatusBadRequest})
+	breaker.done(now, false, serverErr)
+	assert.Equal(t, CircuitClosed, breaker.state, "Failures must be consecutive")
+
+	breaker.done(now, false, serverErr)
+	assert.Equal(t, CircuitOpen, breaker.state)
+	_, err = breaker.allow(now.Add(time.Millisecond))
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	// A single failed probe opens the circuit again
+	probe, err = breaker.allow(now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, probe)
+	_, err = breaker.allow(now.Add(time.Second))
+	assert.Equal(t, ErrCircuitOpen, err, "Only one probe is sent at a time")
+	breaker.done(now.Add(time.Second), false, nil)
+	assert.Equal(t, CircuitHalfOpen, breaker.state, "Requests sent before the circuit opened do not decide")
+	breaker.done(now.Add(time.Second), probe, serverErr)
+	assert.Equal(t, CircuitOpen, breaker.state)
+
+	// A cancelled probe lets another one through
+	probe, _ = breaker.allow(now.Add(2 * time.Second))
+	breaker.done(now.Add(2*time.Second), probe, context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, breaker.state)
+	probe, err = breaker.allow(now.Add(2 * time.Second))
+	assert.NoError(t, err)
+	breaker.done(now.Add(2*time.Second), probe, nil)
+	assert.Equal(t, CircuitClosed, breaker.state)
+
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed",
+	}, transitions)
+	assert.Equal(t, int64(2), stats.CircuitOpenCount)
+	assert.Equal(t, int64(2), stats.CircuitRejectedCount)
+	assert.Equal(t, int64(CircuitClosed), stats.CircuitStateValue)
+}
+
+func TestInsertCircuitBreaker(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var err error
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.RetryWait = time.Millisecond
+	client.CircuitBreakerThreshold = 1
+	client.CircuitBreakerCooldown = time.Hour
+	client.spool, err = openSpool(dir, DefaultSpoolMaxBytes, client.Logger, &client.Statistics)
+	assert.NoError(t, err)
+
+	events := [][]byte{[]byte(`{"eventType":"test"}`)}
+	err = client.sendBatch(context.Background(), events)
+	assert.True(t, errors.Is(err, ErrCircuitOpen), "The retry should fail fast: %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, client.spool.len(), "Batches are spooled while the circuit is open")
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	assert.Equal(t, ErrCircuitOpen, client.PostEvent(testInsertJSONString))
+	client.replaySpool(context.Background())
+	assert.Equal(t, 1, client.spool.len(), "Spooled batches wait for the circuit to close")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(t, int64(3), client.Snapshot().CircuitRejectedCount)
+}
+
+func TestInsertCircuitBreaker_rateLimited(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.MaxRequestsPerSecond = 1
+	client.CircuitBreakerThreshold = 1
+	client.rateLimiter().requests.reserve(1, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.PostEventContext(ctx, `{"eventType":"test"}`)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Got %v", err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	assert.Equal(t, CircuitClosed, client.CircuitState(), "Waiting for the rate limits is not a failure")
+
+	// A probe giving up on the rate limits lets the next request probe
+	breaker := client.circuitBreaker()
+	breaker.mu.Lock()
+	breaker.setState(CircuitHalfOpen)
+	breaker.mu.Unlock()
+	probe, err := breaker.allow(time.Now())
+	assert.NoError(t, err)
+	breaker.release(probe)
+	probe, err = breaker.allow(time.Now())
+	assert.NoError(t, err)
+	assert.True(t, probe)
+}
@@ -1,18 +1,113 @@
 package client
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"net/url"
+	"strings"
 )
 
-// UseCustomURL allows overriding the default Insights Host / Scheme.
-func (c *Client) UseCustomURL(customURL string) {
-	newURL, _ := url.Parse(customURL)
-	if len(newURL.Scheme) < 1 {
-		c.URL.Scheme = "https"
-	} else {
-		c.URL.Scheme = newURL.Scheme
+// Middleware wraps the RoundTripper used to send requests, to add things
+// such as authentication, logging or metrics to every request.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to an http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// defaultHTTPClient is shared by clients without an HTTPClient, so they all
+// reuse the same connection pool.
+var defaultHTTPClient = NewHTTPClient()
+
+// NewHTTPClient returns an http.Client with the default transport settings,
+// pooling up to DefaultMaxIdleConnsPerHost connections to each host. It is a
+// starting point for clients that need proxies, TLS settings, or other
+// transport changes.
+func NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = DefaultMaxIdleConns
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	transport.IdleConnTimeout = DefaultIdleConnTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// UseCustomURL allows overriding the default Insights Host / Scheme. The
+// scheme defaults to https, and a port is kept. When customURL has a path it
+// replaces the whole endpoint path, otherwise the default path is kept. The
+// client is left unchanged if customURL is not a valid http(s) URL.
+func (c *Client) UseCustomURL(customURL string) error {
+	if !strings.Contains(customURL, "://") {
+		customURL = "https://" + customURL
+	}
+
+	newURL, err := url.Parse(customURL)
+	if err != nil {
+		return fmt.Errorf("invalid custom URL: %w", err)
+	}
+	if newURL.Scheme != "http" && newURL.Scheme != "https" {
+		return fmt.Errorf("invalid custom URL %s: scheme must be http or https", customURL)
+	}
+	if newURL.Hostname() == "" {
+		return fmt.Errorf("invalid custom URL %s: missing host", customURL)
 	}
 
-	c.URL.Host = newURL.Host
+	if c.URL == nil {
+		c.URL = &url.URL{}
+	}
+	updated := *c.URL
+	updated.Scheme = newURL.Scheme
+	updated.Host = newURL.Host
+	if strings.Trim(newURL.Path, "/") != "" {
+		updated.Path = newURL.Path
+		updated.RawPath = newURL.RawPath
+	}
+	c.URL = &updated
+
 	c.Logger.Debugf("Using custom URL: %s", c.URL)
+	return nil
+}
+
+// httpClient returns the http.Client to send requests with: HTTPClient, or
+// the shared default, with Middleware wrapped around its transport. It is
+// built on first use, so HTTPClient and Middleware must be set before any
+// request is sent.
+func (c *Client) httpClient() *http.Client {
+	c.httpClientOnce.Do(func() {
+		base := c.HTTPClient
+		if base == nil {
+			base = defaultHTTPClient
+		}
+		if len(c.Middleware) == 0 {
+			c.wrappedHTTPClient = base
+			return
+		}
+
+		transport := base.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		for i := len(c.Middleware) - 1; i >= 0; i-- {
+			transport = c.Middleware[i](transport)
+		}
+
+		wrapped := *base
+		wrapped.Transport = transport
+		c.wrappedHTTPClient = &wrapped
+	})
+
+	return c.wrappedHTTPClient
+}
+
+// requestContext limits ctx to RequestTimeout, if set
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.RequestTimeout)
 }
@@ -23,4 +23,55 @@ func TestUseCustomURL(t *testing.T) {
 
 	c.UseCustomURL("localhost")
 	assert.Equal(t, c.URL.Scheme, "https", "Schema should default to 'https'")
+	assert.Equal(t, c.URL.Host, "localhost", "Host should be set without a scheme")
+}
+
+func TestUseCustomURL_pathAndPort(t *testing.T) {
+	c := &Client{
+		Logger: log.New(),
+	}
+
+	c.URL, _ = url.Parse(insightsInsertURL + "/1/events")
+
+	assert.NoError(t, c.UseCustomURL("http://localhost:8080"))
+	assert.Equal(t, "http://localhost:8080/v1/accounts/1/events", c.URL.String(), "Port should be kept along with the default path")
+
+	assert.NoError(t, c.UseCustomURL("https://proxy.example.com:8443/insights/v1/accounts/2/events"))
+	assert.Equal(t, "https://proxy.example.com:8443/insights/v1/accounts/2/events", c.URL.String(), "Custom path should be kept")
+}
+
+func TestUseCustomURL_bad(t *testing.T) {
+	c := &Client{
+		Logger: log.New(),
+	}
+
+	c.URL, _ = url.Parse(insightsInsertURL + "/1/events")
+	for _, bad := range []string{"ftp://example.com", "http://", "http://exa mple.com"} {
+		assert.Error(t, c.UseCustomURL(bad), bad)
+	}
+	assert.Equal(t, insightsInsertURL+"/1/events", c.URL.String(), "URL should not change on error")
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	good := []string{
+		insightsInsertURL + "/1/events",
+		insightsInsertURLEU + "/1/events",
+		"http://localhost:8080/v1/accounts/1/events",
+		"https://proxy.example.com/insights/v1/accounts/1/events",
+	}
+	for _, u := range good {
+		parsed, _ := url.Parse(u)
+		assert.NoError(t, validateEndpoint(parsed, "events"), u)
+	}
+
+	bad := []string{
+		insightsQueryURL + "/1/query",
+		insightsInsertURL + "/abc/events",
+		"ftp://localhost/v1/accounts/1/events",
+		"/v1/accounts/1/events",
+	}
+	for _, u := range bad {
+		parsed, _ := url.Parse(u)
+		assert.Error(t, validateEndpoint(parsed, "events"), u)
+	}
 }
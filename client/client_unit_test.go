@@ -0,0 +1,70 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientMiddleware(t *testing.T) {
+	var err error
+	var order []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Test-Auth"), "Middleware should modify the request")
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tag := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	auth := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Test-Auth", "secret")
+			return next.RoundTrip(req)
+		})
+	}
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, err = client.URL.Parse(ts.URL)
+	assert.NoError(t, err)
+	client.Middleware = []Middleware{tag("outer"), tag("inner"), auth}
+
+	assert.NoError(t, client.jsonPostRequest(context.Background(), jsonDocument(testInsertJSON[0])))
+	assert.Equal(t, []string{"outer", "inner"}, order, "First middleware should be outermost")
+}
+
+func TestClientHTTPClient(t *testing.T) {
+	var err error
+	var requests int
+
+	ts := httptest.NewServer(testQueryHandlerEmpty)
+	defer ts.Close()
+
+	transport := NewHTTPClient().Transport
+	client := NewQueryClient(testKey, testID)
+	client.URL, err = client.URL.Parse(ts.URL)
+	assert.NoError(t, err)
+	client.HTTPClient = &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return transport.RoundTrip(req)
+	})}
+
+	_, err = client.QueryEvents(testNRQLQuery)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests, "Requests should go through HTTPClient")
+
+	assert.Same(t, defaultHTTPClient, NewInsertClient(testKey, testID).httpClient(), "Clients should share a pooled default")
+}
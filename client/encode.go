@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBufferBytes is the capacity over which request buffers are left
+// to the garbage collector instead of being pooled, so one unusually large
+// batch does not pin its memory for good
+const maxPooledBufferBytes = 4 * DefaultMaxBatchBytes
+
+var (
+	openBracket  = []byte{'['}
+	comma        = []byte{','}
+	closeBracket = []byte{']'}
+)
+
+// requestBody is the JSON sent in an insert request: a single document, or
+// events joined into a JSON array.
+type requestBody struct {
+	parts [][]byte
+	array bool
+}
+
+// jsonDocument is a request body sending doc as is
+func jsonDocument(doc []byte) requestBody {
+	return requestBody{parts: [][]byte{doc}}
+}
+
+// jsonArray is a request body sending events as a JSON array
+func jsonArray(events [][]byte) requestBody {
+	return requestBody{parts: events, array: true}
+}
+
+// size returns the number of bytes of JSON in the body
+func (b requestBody) size() int {
+	size := 0
+	for _, part := range b.parts {
+		size += len(part)
+	}
+	if b.array {
+		size += 2 // brackets
+		if len(b.parts) > 1 {
+			size += len(b.parts) - 1 // commas
+		}
+	}
+	return size
+}
+
+// writeTo writes the JSON of the body to w
+func (b requestBody) writeTo(w io.Writer) error {
+	if b.array {
+		if _, err := w.Write(openBracket); err != nil {
+			return err
+		}
+	}
+	for i, part := range b.parts {
+		if b.array && i > 0 {
+			if _, err := w.Write(comma); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	if b.array {
+		if _, err := w.Write(closeBracket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferBytes {
+		bufferPool.Put(buf)
+	}
+}
+
+// pooledBuffer counts the references to a pooled buffer, and hands it back
+// to the pool once the last one is released
+type pooledBuffer struct {
+	buf  *bytes.Buffer
+	refs int32
+}
+
+// newPooledBuffer wraps buf, holding a single reference
+func newPooledBuffer(buf *bytes.Buffer) *pooledBuffer {
+	return &pooledBuffer{buf: buf, refs: 1}
+}
+
+func (p *pooledBuffer) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		putBuffer(p.buf)
+	}
+}
+
+// newBody returns a reader of the buffer, holding a reference until it is
+// closed
+func (p *pooledBuffer) newBody() io.ReadCloser {
+	atomic.AddInt32(&p.refs, 1)
+	return &pooledBody{Reader: bytes.NewReader(p.buf.Bytes()), buf: p}
+}
+
+// pooledBody is a request body reading a pooledBuffer. The transport may
+// still read it after the request returns, so the buffer is only released
+// when the body is closed.
+type pooledBody struct {
+	*bytes.Reader
+	buf  *pooledBuffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(b.buf.release)
+	return nil
+}
+
+// encodeBody writes body into a pooled buffer, compressed with compression
+// at level. The buffer should be handed back with putBuffer once unused.
+func encodeBody(body requestBody, compression Compression, level int) (*bytes.Buffer, error) {
+	buf := getBuffer()
+
+	if compression == None {
+		buf.Grow(body.size())
+		if err := body.writeTo(buf); err != nil {
+			putBuffer(buf)
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	writer, err := getCompressor(buf, compression, level)
+	if err == nil {
+		if err = body.writeTo(writer); err == nil {
+			err = writer.Close()
+		}
+	}
+	if err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+
+	putCompressor(writer, compression, level)
+	return buf, nil
+}
+
+// compressor is implemented by the writers of every supported Compression
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+type compressorKey struct {
+	compression Compression
+	level       int
+}
+
+// compressorPools holds a *sync.Pool of compressors per compressorKey, as
+// allocating them, flate's in particular, costs far more than compressing
+// a batch of events
+var compressorPools sync.Map
+
+// getCompressor returns a compressor writing to w, reusing a pooled one if
+// possible
+func getCompressor(w io.Writer, compression Compression, level int) (compressor, error) {
+	if pool, ok := compressorPools.Load(compressorKey{compression, level}); ok {
+		if writer, ok := pool.(*sync.Pool).Get().(compressor); ok {
+			writer.Reset(w)
+			return writer, nil
+		}
+	}
+
+	switch compression {
+	case Deflate, Zlib:
+		// The deflate content coding of HTTP is the zlib format
+		return zlib.NewWriterLevel(w, level)
+	case Gzip:
+		return gzip.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", compression)
+	}
+}
+
+// checkCompressionLevel returns an error if level is not accepted by the
+// compressors
+func checkCompressionLevel(level int) error {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", level)
+	}
+	return nil
+}
+
+// putCompressor pools a closed compressor for reuse
+func putCompressor(writer compressor, compression Compression, level int) {
+	key := compressorKey{compression, level}
+	pool, ok := compressorPools.Load(key)
+	if !ok {
+		pool, _ = compressorPools.LoadOrStore(key, &sync.Pool{})
+	}
+	pool.(*sync.Pool).Put(writer)
+}
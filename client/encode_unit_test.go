@@ -0,0 +1,151 @@
+// +build unit
+
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// benchmarkEvents is a batch of DefaultBatchEventCount events
+var benchmarkEvents = func() [][]byte {
+	events := make([][]byte, DefaultBatchEventCount)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf(`{"eventType":"Benchmark","index":%d,"host":"host-%d.example.com","duration":%d.25}`, i, i%16, i*7))
+	}
+	return events
+}()
+
+// discardTransport reads and discards request bodies, answering with success
+var discardTransport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+	_, err := io.Copy(ioutil.Discard, req.Body)
+	req.Body.Close()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(testInsertResponseJSON["success"])),
+		Request:    req,
+	}, err
+})
+
+// decompress reverses encodeBody
+func decompress(r io.Reader, compression Compression) ([]byte, error) {
+	var err error
+	reader := r
+
+	switch compression {
+	case Deflate, Zlib:
+		reader, err = zlib.NewReader(r)
+	case Gzip:
+		reader, err = gzip.NewReader(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
+func TestRequestBody(t *testing.T) {
+	for _, tc := range []struct {
+		body requestBody
+		json string
+	}{
+		{jsonDocument([]byte(`{"a":1}`)), `{"a":1}`},
+		{jsonDocument([]byte(`[{"a":1}]`)), `[{"a":1}]`},
+		{jsonArray(nil), `[]`},
+		{jsonArray([][]byte{[]byte(`{"a":1}`)}), `[{"a":1}]`},
+		{jsonArray([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`), []byte(`{}`)}), `[{"a":1},{"b":2},{}]`},
+	} {
+		var buf bytes.Buffer
+		assert.NoError(t, tc.body.writeTo(&buf))
+		assert.Equal(t, tc.json, buf.String())
+		assert.Equal(t, len(tc.json), tc.body.size(), tc.json)
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	body := jsonArray(benchmarkEvents)
+	var expected bytes.Buffer
+	assert.NoError(t, body.writeTo(&expected))
+
+	for _, compression := range []Compression{None, Deflate, Gzip, Zlib} {
+		// The second time around, pooled buffers and compressors are reused
+		for i := 0; i < 2; i++ {
+			buf, err := encodeBody(body, compression, DefaultCompressionLevel)
+			assert.NoError(t, err)
+
+			decoded, err := decompress(bytes.NewReader(buf.Bytes()), compression)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Bytes(), decoded, "Body should round trip with %s", compression)
+			putBuffer(buf)
+		}
+	}
+
+	for _, compression := range []Compression{Deflate, Gzip, Zlib} {
+		for _, level := range []int{flate.HuffmanOnly, flate.BestSpeed, flate.BestCompression} {
+			buf, err := encodeBody(body, compression, level)
+			assert.NoError(t, err)
+
+			decoded, err := decompress(bytes.NewReader(buf.Bytes()), compression)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Bytes(), decoded, "%s level %d should round trip", compression, level)
+			putBuffer(buf)
+		}
+	}
+
+	_, err := encodeBody(body, Gzip, 42)
+	assert.Error(t, err, "Invalid levels are reported")
+}
+
+func benchmarkSendEvents(b *testing.B, compression Compression) {
+	client := NewInsertClient(testKey, testID)
+	client.HTTPClient = &http.Client{Transport: discardTransport}
+	client.Compression = compression
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.sendEvents(context.Background(), benchmarkEvents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendEvents_950(b *testing.B)     { benchmarkSendEvents(b, None) }
+func BenchmarkSendEvents_950Gzip(b *testing.B) { benchmarkSendEvents(b, Gzip) }
+
+func TestPooledBuffer(t *testing.T) {
+	buf := getBuffer()
+	buf.WriteString(testInsertJSONString)
+	pooled := newPooledBuffer(buf)
+
+	body := pooled.newBody()
+	replayed := pooled.newBody() // As made by GetBody
+	pooled.release()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&pooled.refs), "Open bodies keep the buffer")
+
+	// The transport may read the body after the request returned
+	data, err := ioutil.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, testInsertJSONString, string(data))
+	assert.NoError(t, body.Close())
+	assert.NoError(t, body.Close(), "Closing twice should release once")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&pooled.refs))
+
+	data, err = ioutil.ReadAll(replayed)
+	assert.NoError(t, err)
+	assert.Equal(t, testInsertJSONString, string(data))
+	assert.NoError(t, replayed.Close())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&pooled.refs))
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrClientClosed is returned when using an InsertClient after Close has been called
+var ErrClientClosed = errors.New("the Insights client has been closed")
+
+// ErrNotStarted is returned when using the batch mode of an InsertClient
+// before Start has been called
+var ErrNotStarted = errors.New("queueing not enabled for this client")
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("the Insights circuit breaker is open")
+
+// ErrQueueFull is returned by EnqueueEvent when the event was dropped because
+// the queue is full
+var ErrQueueFull = errors.New("the Insights event queue is full")
+
+// ErrEventTooLarge is returned by EnqueueEvent when an event would not fit
+// in a batch of MaxBatchBytes on its own
+var ErrEventTooLarge = errors.New("the Insights event is larger than MaxBatchBytes")
+
+// BatchError is reported to InsertClient.OnError when a batch of events is
+// abandoned after every send attempt failed.
+type BatchError struct {
+	// Events holds the marshalled JSON of every event in the batch
+	Events [][]byte
+	// Attempts is the number of times the batch was sent
+	Attempts int
+	// StatusCode is the HTTP status of the last attempt, 0 if no response was received
+	StatusCode int
+	// Err is the error from the last attempt
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("failed to send %d insights events after %d attempts: %v", len(e.Events), e.Attempts, e.Err)
+}
+
+// Unwrap returns the error from the last attempt
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// maxErrorBodyBytes is how much of a response body APIError.Error shows
+const maxErrorBodyBytes = 512
+
+// APIError is returned by both clients when Insights responds to a request
+// with anything but success.
+type APIError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Message is the error reported by Insights in the response, if any
+	Message string
+	// Body is the body of the response
+	Body string
+	// RequestID is the X-Request-Id header of the response, if any
+	RequestID string
+	// RetryAfter is the wait asked for by the Retry-After header of a 429
+	// or 503 response
+	RetryAfter time.Duration
+}
+
+// newAPIError builds the error for a response with the given body
+func newAPIError(response *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: response.StatusCode,
+		Body:       string(body),
+		RequestID:  response.Header.Get("X-Request-Id"),
+		RetryAfter: parseRetryAfter(response),
+	}
+
+	var reported struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &reported) == nil {
+		e.Message = reported.Error
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "bad response from Insights: %d", e.StatusCode)
+
+	switch {
+	case e.Message != "":
+		msg.WriteString(": " + e.Message)
+	case len(e.Body) > maxErrorBodyBytes:
+		msg.WriteString(": " + e.Body[:maxErrorBodyBytes] + "...")
+	case e.Body != "":
+		msg.WriteString(": " + e.Body)
+	}
+
+	if e.RequestID != "" {
+		msg.WriteString(" (request " + e.RequestID + ")")
+	}
+	return msg.String()
+}
+
+// Retryable reports whether the request could succeed if sent again: the
+// status is a server error, 408 or 429
+func (e *APIError) Retryable() bool {
+	return retryableStatus(e.StatusCode)
+}
+
+// statusCode extracts the HTTP status from an error, 0 if there is none
+func statusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// retryableStatus reports whether a request that got the given status (0 for
+// no response at all) could succeed if tried again.
+func retryableStatus(code int) bool {
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= 400 && code < 500:
+		return false
+	default:
+		return true
+	}
+}
+
+// multiError aggregates several errors into one, e.g. every batch that
+// failed while shutting down the client.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i := range m {
+		msgs[i] = m[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the aggregated errors matches target
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first aggregated error that matches target
+func (m multiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// combineErrors returns nil, the single error, or a multiError, skipping
+// nil errors
+func combineErrors(errs []error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	default:
+		return multiError(nonNil)
+	}
+}
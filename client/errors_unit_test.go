@@ -0,0 +1,70 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc-123")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": "invalid key"}`))
+	}))
+	defer ts.Close()
+
+	insertClient := NewInsertClient(testKey, testID)
+	insertClient.URL, _ = insertClient.URL.Parse(ts.URL)
+	queryClient := NewQueryClient(testKey, testID)
+	queryClient.URL, _ = queryClient.URL.Parse(ts.URL)
+	_, queryErr := queryClient.QueryEvents(testNRQLQuery)
+
+	for _, err := range []error{insertClient.PostEvent(testInsertJSONString), queryErr} {
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr), "%v should be an *APIError", err)
+		assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+		assert.Equal(t, "invalid key", apiErr.Message)
+		assert.Equal(t, `{"error": "invalid key"}`, apiErr.Body)
+		assert.Equal(t, "abc-123", apiErr.RequestID)
+		assert.False(t, apiErr.Retryable())
+		assert.Contains(t, err.Error(), "bad response from Insights: 403: invalid key (request abc-123)")
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	assert.True(t, (&APIError{StatusCode: http.StatusBadGateway}).Retryable())
+	assert.Equal(t, "bad response from Insights: 502", (&APIError{StatusCode: http.StatusBadGateway}).Error())
+	assert.Equal(t, "bad response from Insights: 502: <html>", (&APIError{StatusCode: http.StatusBadGateway, Body: "<html>"}).Error())
+
+	long := &APIError{StatusCode: http.StatusBadGateway, Body: strings.Repeat("x", 2*maxErrorBodyBytes)}
+	assert.True(t, len(long.Error()) < maxErrorBodyBytes+50, "Long bodies are truncated")
+}
+
+func TestInsertErrorValues(t *testing.T) {
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+
+	assert.True(t, errors.Is(client.EnqueueEvent(testInsertJSONString), ErrNotStarted))
+	assert.True(t, errors.Is(client.Flush(), ErrNotStarted))
+	assert.True(t, errors.Is(client.Close(context.Background()), ErrNotStarted))
+
+	assert.NoError(t, client.Start())
+	assert.NoError(t, client.Close(context.Background()))
+	assert.True(t, errors.Is(client.EnqueueEvent(testInsertJSONString), ErrClientClosed))
+
+	var validationErr *ValidationError
+	err := client.PostEvent(`{"eventType": "bad type!"}`)
+	assert.True(t, errors.As(err, &validationErr), "%v should be a *ValidationError", err)
+	assert.NotEmpty(t, validationErr.Violations)
+}
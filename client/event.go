@@ -0,0 +1,238 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// attributeKind is the type of an Event attribute
+type attributeKind int
+
+const (
+	stringAttribute attributeKind = iota
+	intAttribute
+	floatAttribute
+	boolAttribute
+)
+
+// eventAttribute is a single typed attribute of an Event
+type eventAttribute struct {
+	name string
+	kind attributeKind
+	s    string
+	i    int64
+	f    float64
+	b    bool
+}
+
+// Event is an Insights event built from typed attributes. It is encoded
+// by InsertClient without going through reflection.
+//
+//	event := client.NewEvent("Purchase").
+//		SetString("item", "widget").
+//		SetInt("quantity", 3).
+//		SetFloat("price", 9.99)
+//
+// An Event is not safe for concurrent modification.
+type Event struct {
+	eventType string
+	attrs     []eventAttribute
+	index     map[string]int // attribute name to position in attrs
+}
+
+// NewEvent starts an event of the given eventType
+func NewEvent(eventType string) *Event {
+	return &Event{
+		eventType: eventType,
+		index:     make(map[string]int),
+	}
+}
+
+// EventType returns the eventType the Event was created with
+func (e *Event) EventType() string {
+	return e.eventType
+}
+
+// SetString sets a string attribute
+func (e *Event) SetString(name, value string) *Event {
+	return e.set(eventAttribute{name: name, kind: stringAttribute, s: value})
+}
+
+// SetInt sets an integer attribute
+func (e *Event) SetInt(name string, value int64) *Event {
+	return e.set(eventAttribute{name: name, kind: intAttribute, i: value})
+}
+
+// SetFloat sets a floating point attribute
+func (e *Event) SetFloat(name string, value float64) *Event {
+	return e.set(eventAttribute{name: name, kind: floatAttribute, f: value})
+}
+
+// SetBool sets a boolean attribute
+func (e *Event) SetBool(name string, value bool) *Event {
+	return e.set(eventAttribute{name: name, kind: boolAttribute, b: value})
+}
+
+// SetTime sets an attribute to t, in milliseconds since the epoch
+func (e *Event) SetTime(name string, t time.Time) *Event {
+	return e.SetInt(name, t.UnixNano()/int64(time.Millisecond))
+}
+
+// SetTimestamp sets when the event happened. Insights uses the time the
+// event was received if it is not set.
+func (e *Event) SetTimestamp(t time.Time) *Event {
+	return e.SetTime("timestamp", t)
+}
+
+// set adds the attribute, replacing any previous value with the same name
+func (e *Event) set(attr eventAttribute) *Event {
+	if e.index == nil {
+		e.index = make(map[string]int)
+	}
+
+	if i, ok := e.index[attr.name]; ok {
+		e.attrs[i] = attr
+	} else {
+		e.index[attr.name] = len(e.attrs)
+		e.attrs = append(e.attrs, attr)
+	}
+	return e
+}
+
+// Len returns the number of attributes, not counting eventType
+func (e *Event) Len() int {
+	return len(e.attrs)
+}
+
+// Validate checks the event against the Insights rules, returning a
+// *ValidationError listing every violation found.
+func (e *Event) Validate() error {
+	var violations []Violation
+	violate := func(attr, rule, msg string) {
+		violations = append(violations, Violation{Attribute: attr, Rule: rule, Message: msg})
+	}
+
+	if msg := checkEventType(e.eventType); msg != "" {
+		violate("eventType", RuleEventType, msg)
+	}
+
+	for _, attr := range e.attrs {
+		if attr.name == "eventType" {
+			violate(attr.name, RuleReservedName, "eventType is set by NewEvent")
+			continue
+		}
+		if rule, msg := checkAttributeName(attr.name); rule != "" {
+			violate(attr.name, rule, msg)
+			continue
+		}
+
+		switch attr.kind {
+		case stringAttribute:
+			if len(attr.s) > MaxStringValueLength {
+				violate(attr.name, RuleValueLength, fmt.Sprintf("value is %d bytes, longer than %d", len(attr.s), MaxStringValueLength))
+			}
+		case floatAttribute:
+			if math.IsInf(attr.f, 0) || math.IsNaN(attr.f) {
+				violate(attr.name, RuleUnsupportedType, fmt.Sprintf("value %v is not a supported number", attr.f))
+			}
+		}
+	}
+
+	if len(e.attrs)+1 > MaxEventAttributes {
+		violate("", RuleTooManyAttrs, fmt.Sprintf("event has %d attributes, more than %d", len(e.attrs)+1, MaxEventAttributes))
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the event as a JSON object
+func (e *Event) MarshalJSON() ([]byte, error) {
+	size := len(`{"eventType":""}`) + len(e.eventType)
+	for _, attr := range e.attrs {
+		size += len(attr.name) + len(attr.s) + 24
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, `{"eventType":`...)
+	buf = appendJSONString(buf, e.eventType)
+
+	for _, attr := range e.attrs {
+		if attr.name == "eventType" {
+			continue
+		}
+
+		buf = append(buf, ',')
+		buf = appendJSONString(buf, attr.name)
+		buf = append(buf, ':')
+
+		switch attr.kind {
+		case stringAttribute:
+			buf = appendJSONString(buf, attr.s)
+		case intAttribute:
+			buf = strconv.AppendInt(buf, attr.i, 10)
+		case floatAttribute:
+			if math.IsInf(attr.f, 0) || math.IsNaN(attr.f) {
+				return nil, fmt.Errorf("unsupported value for %s: %v", attr.name, attr.f)
+			}
+			buf = strconv.AppendFloat(buf, attr.f, 'g', -1, 64)
+		case boolAttribute:
+			buf = strconv.AppendBool(buf, attr.b)
+		}
+	}
+
+	return append(buf, '}'), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s to buf as a quoted JSON string. Invalid UTF-8
+// is replaced with U+FFFD, like encoding/json does.
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+
+			buf = append(buf, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
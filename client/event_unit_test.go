@@ -0,0 +1,137 @@
+// +build unit
+
+package client
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventMarshalJSON(t *testing.T) {
+	ts := time.Unix(1500000000, 123000000)
+	event := NewEvent("test").
+		SetString("str", "a \"quoted\"\n\x01 string \xff é").
+		SetInt("int", -42).
+		SetFloat("float", 1.5).
+		SetBool("bool", true).
+		SetTime("time", ts).
+		SetTimestamp(ts).
+		SetInt("int", 42) // Replaces the previous value
+
+	assert.Equal(t, "test", event.EventType())
+	assert.Equal(t, 6, event.Len())
+
+	data, err := event.MarshalJSON()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"eventType":"test","str":`), "Attributes should keep their order")
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[string]interface{}{
+		"eventType": "test",
+		"str":       "a \"quoted\"\n\x01 string � é",
+		"int":       float64(42),
+		"float":     1.5,
+		"bool":      true,
+		"time":      float64(1500000000123),
+		"timestamp": float64(1500000000123),
+	}, decoded)
+
+	// Same result through encoding/json
+	viaJSON, err := json.Marshal(event)
+	assert.NoError(t, err)
+	assert.Equal(t, data, viaJSON)
+}
+
+func TestEventMarshalJSON_badFloat(t *testing.T) {
+	_, err := NewEvent("test").SetFloat("nan", math.NaN()).MarshalJSON()
+	assert.Error(t, err)
+}
+
+func TestEventValidate(t *testing.T) {
+	assert.NoError(t, NewEvent("test").SetString("str", "ok").Validate())
+
+	err := NewEvent("select").
+		SetInt("accountId", 1).
+		SetString("eventType", "other").
+		SetString("str", strings.Repeat("x", MaxStringValueLength+1)).
+		SetFloat("inf", math.Inf(1)).
+		Validate()
+	assert.Equal(t, []string{RuleEventType, RuleReservedName, RuleReservedName, RuleValueLength, RuleUnsupportedType}, violatedRules(err))
+
+	event := NewEvent("test")
+	for i := 0; i < MaxEventAttributes; i++ {
+		event.SetInt(strings.Repeat("a", i+1), int64(i))
+	}
+	assert.Equal(t, []string{RuleTooManyAttrs}, violatedRules(event.Validate()))
+}
+
+func TestInsertPostEvent_event(t *testing.T) {
+	var err error
+	var received map[string]interface{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	err = client.PostEvent(NewEvent("test").SetInt("num", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"eventType": "test", "num": float64(1)}, received)
+
+	err = client.PostEvent(NewEvent("bad type"))
+	assert.Error(t, err)
+}
+
+func TestInsertEnqueueEvent_event(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.eventQueue = make(chan []byte, 1)
+
+	err := client.EnqueueEvent(NewEvent("test").SetString("str", "value"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"eventType":"test","str":"value"}`, string(<-client.eventQueue))
+}
+
+func BenchmarkEventMarshalJSON(b *testing.B) {
+	event := NewEvent("test").
+		SetString("str", "some string value").
+		SetInt("int", 42).
+		SetFloat("float", 1.5).
+		SetBool("bool", true)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := event.MarshalJSON(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEventMarshalJSON_map(b *testing.B) {
+	event := map[string]interface{}{
+		"eventType": "test",
+		"str":       "some string value",
+		"int":       42,
+		"float":     1.5,
+		"bool":      true,
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
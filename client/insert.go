@@ -1,17 +1,16 @@
 package client
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
-	"regexp"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -22,9 +21,11 @@ import (
 func NewInsertClient(insertKey string, accountID string) *InsertClient {
 	client := &InsertClient{}
 	client.URL = createInsertURL(accountID)
+	client.accountID = accountID
 	client.InsertKey = insertKey
 	client.Logger = log.New()
 	client.Compression = None
+	client.CompressionLevel = DefaultCompressionLevel
 
 	// Defaults
 	client.RequestTimeout = DefaultInsertRequestTimeout
@@ -36,42 +37,145 @@ func NewInsertClient(insertKey string, accountID string) *InsertClient {
 	client.WorkerCount = DefaultWorkerCount
 	client.BatchTime = DefaultBatchTimeout
 	client.BatchSize = DefaultBatchEventCount
+	client.MaxBatchBytes = DefaultMaxBatchBytes
+	client.SpoolMaxBytes = DefaultSpoolMaxBytes
+	client.SpoolReplayInterval = DefaultSpoolReplayInterval
+	client.CircuitBreakerCooldown = DefaultCircuitBreakerCooldown
 
 	return client
 }
 
 func createInsertURL(accountID string) *url.URL {
-	insightsURL, _ := url.Parse(insightsInsertURL)
-	insightsURL.Path = fmt.Sprintf("%s/%s/events", insightsURL.Path, accountID)
-	return insightsURL
+	return endpointURL(insightsInsertURL, accountID, "events")
 }
 
 // Start runs the insert client in batch mode.
+//
+// A client that has been stopped with Close may be started again once Close
+// has returned without error; statistics are preserved across restarts.
 func (c *InsertClient) Start() error {
-	if c.eventQueue != nil {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.eventQueue != nil && !c.closed {
 		return errors.New("the Insights client is already in daemon mode")
 	}
 
+	if c.stopped != nil {
+		select {
+		case <-c.stopped:
+		default:
+			return errors.New("the Insights client is still shutting down")
+		}
+	}
+
+	if c.QueueFullPolicy == QueueSpillToDisk && c.SpoolDir == "" {
+		return errors.New("the SpillToDisk queue policy requires a SpoolDir")
+	}
+
+	if c.Compression != None {
+		if err := checkCompressionLevel(c.CompressionLevel); err != nil {
+			return err
+		}
+	}
+
+	// Spool settings left at zero, as in a struct literal, use the defaults
+	spoolMaxBytes := c.SpoolMaxBytes
+	if spoolMaxBytes <= 0 {
+		spoolMaxBytes = DefaultSpoolMaxBytes
+	}
+	replayInterval := c.SpoolReplayInterval
+	if replayInterval <= 0 {
+		replayInterval = DefaultSpoolReplayInterval
+	}
+
+	if c.SpoolDir != "" {
+		s, err := openSpool(c.SpoolDir, spoolMaxBytes, c.Logger, &c.Statistics)
+		if err != nil {
+			return err
+		}
+		c.spool = s
+	}
+
+	workerCount := c.WorkerCount
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
 	c.eventQueue = make(chan []byte, c.BatchSize)
+	c.batchQueue = make(chan *queuedBatch, workerCount)
 	c.eventTimer = time.NewTimer(c.BatchTime)
-	c.flushQueue = make(chan bool, c.WorkerCount)
+	c.flushQueue = make(chan *flushRequest, workerCount)
+	c.quit = make(chan struct{})
+	c.stop = make(chan struct{})
+	c.stopped = nil
+	c.closed = false
+	c.shutdownErrs = nil
+
+	// Cancelled if Close gives up on the senders
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	c.cancelSends = cancelSends
+
+	c.senders.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			defer c.senders.Done()
+			c.sendWorker(sendCtx)
+		}()
+	}
 
-	// TODO: errors returned from the call to watchdog()
-	// and batchWorker() are simply dropped on the floor.
+	c.workers.Add(2)
 	go func() {
+		defer c.workers.Done()
 		err := c.watchdog()
 		if err != nil {
-			log.Errorf("watchdog returned error: %v", err)
+			c.reportError(fmt.Errorf("watchdog returned error: %w", err))
 		}
 	}()
 
 	go func() {
+		defer c.workers.Done()
 		err := c.batchWorker()
 		if err != nil {
-			log.Errorf("batch worker returned error: %v", err)
+			c.reportError(fmt.Errorf("batch worker returned error: %w", err))
 		}
 	}()
 
+	if c.spool != nil {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			err := c.replayWorker(sendCtx, replayInterval)
+			if err != nil {
+				c.reportError(fmt.Errorf("replay worker returned error: %w", err))
+			}
+		}()
+	}
+
+	c.spillQueue = nil
+	if c.QueueFullPolicy == QueueSpillToDisk {
+		c.spillQueue = make(chan []byte, c.BatchSize)
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			err := c.spillWorker()
+			if err != nil {
+				c.reportError(fmt.Errorf("spill worker returned error: %w", err))
+			}
+		}()
+	}
+
+	if c.SelfTelemetryInterval > 0 {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			err := c.telemetryWorker()
+			if err != nil {
+				c.reportError(fmt.Errorf("telemetry worker returned error: %w", err))
+			}
+		}()
+	}
+
 	c.Logger.Infof("the Insights client has launched in daemon mode with endpoint %s", c.URL)
 
 	return nil
@@ -82,7 +186,7 @@ func (c *InsertClient) Start() error {
 //
 func (c *InsertClient) StartListener(inputChannel chan interface{}) (err error) {
 	// Allow this to be called instead of Start()
-	if c.eventQueue == nil {
+	if !c.isRunning() {
 		if err = c.Start(); err != nil {
 			return err
 		}
@@ -91,10 +195,20 @@ func (c *InsertClient) StartListener(inputChannel chan interface{}) (err error)
 		return errors.New("channel to listen is nil")
 	}
 
+	// Close must not be waiting on the workers when one is added
+	c.stateMu.RLock()
+	if c.closed {
+		c.stateMu.RUnlock()
+		return ErrClientClosed
+	}
+	c.workers.Add(1)
+	c.stateMu.RUnlock()
+
 	go func() {
+		defer c.workers.Done()
 		err := c.queueWorker(inputChannel)
 		if err != nil {
-			log.Errorf("queue worker returned error: %v", err)
+			c.reportError(fmt.Errorf("queue worker returned error: %w", err))
 		}
 	}()
 
@@ -103,15 +217,89 @@ func (c *InsertClient) StartListener(inputChannel chan interface{}) (err error)
 	return nil
 }
 
+// Close stops the batch mode of the client. New events are rejected with
+// ErrClientClosed, every event still buffered is flushed, and Close waits
+// for all in-flight sends (including retries) to finish or for ctx to be
+// done, whichever comes first. Once ctx is done, the sends still in flight
+// are cancelled without further retries, and their batches are spooled if
+// SpoolDir is set or reported to OnError otherwise.
+//
+// The returned error aggregates the failures of every batch that could not
+// be delivered during shutdown, as well as ctx.Err() if the deadline was hit.
+func (c *InsertClient) Close(ctx context.Context) error {
+	c.stateMu.Lock()
+	if c.eventQueue == nil {
+		c.stateMu.Unlock()
+		return ErrNotStarted
+	}
+	if c.closed {
+		c.stateMu.Unlock()
+		return ErrClientClosed
+	}
+
+	c.closed = true
+	quit, stop, cancelSends := c.quit, c.stop, c.cancelSends
+	stopped := make(chan struct{})
+	c.stopped = stopped
+	c.stateMu.Unlock()
+
+	c.Logger.Debug("Closing insights client")
+	close(quit)
+
+	go func() {
+		// Only once nobody can add to the queue is it safe to drain it
+		c.enqueuers.Wait()
+		close(stop)
+		c.workers.Wait()
+		// batchWorker is done, let the senders finish what is queued
+		close(c.batchQueue)
+		c.senders.Wait()
+		cancelSends()
+		close(stopped)
+	}()
+
+	var ctxErr error
+	select {
+	case <-stopped:
+		c.Logger.Info("the Insights client has shut down")
+	case <-ctx.Done():
+		ctxErr = ctx.Err()
+		// Don't leave the senders sleeping through their backoffs
+		cancelSends()
+	}
+
+	c.stateMu.RLock()
+	errs := append([]error(nil), c.shutdownErrs...)
+	c.stateMu.RUnlock()
+
+	if ctxErr != nil {
+		errs = append(errs, ctxErr)
+	}
+
+	return combineErrors(errs)
+}
+
+// isRunning reports whether the client is in batch mode and not closed
+func (c *InsertClient) isRunning() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return c.eventQueue != nil && !c.closed
+}
+
 // Validate makes sure the InsertClient is configured correctly for use
 func (c *InsertClient) Validate() error {
-	if correct, _ := regexp.MatchString("collector.newrelic.com/v1/accounts/[0-9]+/events", c.URL.String()); !correct {
-		return fmt.Errorf("invalid insert endpoint %s", c.URL)
+	if err := validateEndpoint(c.URL, "events"); err != nil {
+		return err
 	}
 
 	if len(c.InsertKey) < 1 {
 		return fmt.Errorf("not a valid license key: %s", c.InsertKey)
 	}
+
+	if c.Compression != None {
+		return checkCompressionLevel(c.CompressionLevel)
+	}
 	return nil
 }
 
@@ -124,68 +312,307 @@ func (c *InsertClient) EnqueueEvent(data interface{}) (err error) {
 // forever until the event can be queued, provide a ctx with a deadline or timeout as this function will
 // bail when ctx.Done() is closed and return and error.
 func (c *InsertClient) EnqueueEventContext(ctx context.Context, data interface{}) (err error) {
+	c.stateMu.RLock()
 	if c.eventQueue == nil {
-		return errors.New("queueing not enabled for this client")
+		c.stateMu.RUnlock()
+		return ErrNotStarted
+	}
+	if c.closed {
+		c.stateMu.RUnlock()
+		return ErrClientClosed
 	}
+	queue, quit := c.eventQueue, c.quit
+	c.enqueuers.Add(1)
+	c.stateMu.RUnlock()
+	defer c.enqueuers.Done()
 
 	var jsonData []byte
 	atomic.AddInt64(&c.Statistics.EventCount, 1)
 
-	if jsonData, err = json.Marshal(data); err != nil {
+	if jsonData, err = c.marshalEvent(data); err != nil {
 		return err
 	}
 
+	var kept bool
+	if jsonData, kept = c.sampleEvent(data, jsonData); !kept {
+		return nil
+	}
+
+	// A batch holding only this event would still be too large, once
+	// compressed as well as the previous batches
+	if c.exceedsMaxBatchBytes(len(jsonData) + 2) {
+		return fmt.Errorf("%w: event of %d bytes, MaxBatchBytes is %d", ErrEventTooLarge, len(jsonData), c.MaxBatchBytes)
+	}
+
+	return c.enqueue(ctx, queue, quit, jsonData)
+}
+
+// enqueue puts a marshalled event in the queue, applying the QueueFullPolicy
+// if the queue is full or MaxBufferedBytes is reached.
+func (c *InsertClient) enqueue(ctx context.Context, queue chan []byte, quit chan struct{}, jsonData []byte) error {
+	size := int64(len(jsonData))
+	reserved := c.reserveBytes(size)
+	if reserved {
+		select {
+		case queue <- jsonData:
+			return nil
+		default:
+		}
+	} else {
+		// Events in the batch being built hold bytes until it is sent
+		c.requestFlush()
+	}
+
+	switch c.QueueFullPolicy {
+	case QueueDropNewest:
+		if reserved {
+			c.releaseBytes(size)
+		}
+		c.dropEvent(jsonData)
+		return ErrQueueFull
+
+	case QueueDropOldest:
+		for {
+			if !reserved {
+				reserved = c.reserveBytes(size)
+			}
+			if reserved {
+				select {
+				case queue <- jsonData:
+					return nil
+				default:
+				}
+			}
+
+			select {
+			case oldest := <-queue:
+				c.releaseBytes(int64(len(oldest)))
+				c.dropEvent(oldest)
+			default:
+				if !reserved {
+					// The budget is held by batches being sent, which can't be dropped
+					c.dropEvent(jsonData)
+					return ErrQueueFull
+				}
+			}
+		}
+
+	case QueueSpillToDisk:
+		if reserved {
+			c.releaseBytes(size)
+		}
+		if c.spill(jsonData) {
+			return nil
+		}
+		c.dropEvent(jsonData)
+		return ErrQueueFull
+	}
+
+	if !reserved {
+		if err := c.waitForBytes(ctx, quit, size); err != nil {
+			return err
+		}
+	}
+
 	select {
-	case c.eventQueue <- jsonData:
+	case queue <- jsonData:
 		return nil
+	case <-quit:
+		c.releaseBytes(size)
+		return ErrClientClosed
 	case <-ctx.Done():
+		c.releaseBytes(size)
 		return ctx.Err()
 	}
 }
 
-// PostEvent allows sending a single event directly.
+// dropEvent accounts for an event dropped because the queue is full
+func (c *InsertClient) dropEvent(event []byte) {
+	atomic.AddInt64(&c.Statistics.DroppedEventCount, 1)
+	if c.OnDrop != nil {
+		c.OnDrop(event)
+	}
+}
+
+// PostEvent allows sending a single event directly. data may also be a
+// JSON array of events, as []byte or string.
 func (c *InsertClient) PostEvent(data interface{}) error {
+	return c.PostEventContext(context.Background(), data)
+}
+
+// PostEventContext is PostEvent, giving up when ctx is done
+func (c *InsertClient) PostEventContext(ctx context.Context, data interface{}) error {
 	var jsonData []byte
+	var err error
 
 	switch data := data.(type) {
 	case []byte:
-		jsonData = data
+		if jsonData, err = c.addCommonAttributes(data); err == nil {
+			jsonData, err = c.validateEvent(jsonData)
+		}
 	case string:
-		jsonData = []byte(data)
-	default:
-		var jsonErr error
-		jsonData, jsonErr = json.Marshal(data)
-		if jsonErr != nil {
-			return fmt.Errorf("error marshaling event data: %s", jsonErr.Error())
+		if jsonData, err = c.addCommonAttributes([]byte(data)); err == nil {
+			jsonData, err = c.validateEvent(jsonData)
 		}
+	default:
+		jsonData, err = c.marshalEvent(data)
 	}
-
-	// Needs to handle array of events. maybe pull into separate validation func
-	if !strings.Contains(string(jsonData), "eventType") {
-		return fmt.Errorf("event data must contain eventType field. %s", jsonData)
+	if err != nil {
+		return err
 	}
 
 	c.Logger.Debugf("Posting to insights: %s", jsonData)
 
-	if requestErr := c.jsonPostRequest(jsonData); requestErr != nil {
-		return requestErr
+	// Every event of an array counts towards the rate limits
+	events, isArray := arrayElements(jsonData)
+	if !isArray {
+		events = [][]byte{jsonData}
 	}
 
-	return nil
+	return c.post(ctx, events, jsonDocument(jsonData), nil)
+}
+
+// marshalEvent encodes an event as JSON and validates it. An *Event is
+// encoded directly, structs with insights tags or an EventType method are
+// converted to an Event first, anything else goes through encoding/json.
+func (c *InsertClient) marshalEvent(data interface{}) ([]byte, error) {
+	event, ok := data.(*Event)
+	if !ok {
+		var err error
+		if event, ok, err = eventFromStruct(data); err != nil {
+			return nil, fmt.Errorf("error marshaling event data: %w", err)
+		}
+	}
+
+	if ok {
+		jsonData, err := event.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling event data: %w", err)
+		}
+		if jsonData, err = c.addCommonAttributes(jsonData); err != nil {
+			return nil, err
+		}
+		// Common attributes are checked when encoded, except for their number
+		if event.Validate() == nil && c.CommonAttributesFunc == nil && event.Len()+1+len(c.commonEncoded) <= MaxEventAttributes {
+			return jsonData, nil
+		}
+		return c.validateEvent(jsonData)
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling event data: %w", err)
+	}
+	if jsonData, err = c.addCommonAttributes(jsonData); err != nil {
+		return nil, err
+	}
+
+	return c.validateEvent(jsonData)
 }
 
 // Flush gives the user a way to manually flush the queue in the foreground.
-// This is also used by watchdog when the timer expires.
+// It returns once the batch worker has been asked to flush, without waiting
+// for the batch to be sent. This is also used by watchdog when the timer
+// expires.
 func (c *InsertClient) Flush() error {
-	if c.flushQueue == nil {
-		return errors.New("queueing not enabled for this client")
+	c.stateMu.RLock()
+	flushQueue, quit, closed := c.flushQueue, c.quit, c.closed
+	c.stateMu.RUnlock()
+
+	if flushQueue == nil {
+		return ErrNotStarted
+	}
+	if closed {
+		return ErrClientClosed
 	}
 	c.Logger.Debug("Flushing insights client")
 	atomic.AddInt64(&c.Statistics.FlushCount, 1)
 
-	c.flushQueue <- true
+	select {
+	case flushQueue <- nil:
+		return nil
+	case <-quit:
+		return ErrClientClosed
+	}
+}
 
-	return nil
+// FlushContext sends every event enqueued so far, and waits until the
+// batches holding them have been sent or have failed. It returns the errors
+// of the failed batches, which are also handed to OnError or spooled as
+// usual, or ctx.Err() if ctx is done first; the batches are still sent in
+// that case.
+func (c *InsertClient) FlushContext(ctx context.Context) error {
+	c.stateMu.RLock()
+	flushQueue, quit := c.flushQueue, c.quit
+	if flushQueue == nil {
+		c.stateMu.RUnlock()
+		return ErrNotStarted
+	}
+	if c.closed {
+		c.stateMu.RUnlock()
+		return ErrClientClosed
+	}
+	c.enqueuers.Add(1) // Close waits for the request to reach the batch worker
+	c.stateMu.RUnlock()
+
+	c.Logger.Debug("Flushing insights client")
+	atomic.AddInt64(&c.Statistics.FlushCount, 1)
+
+	req := newFlushRequest()
+	select {
+	case flushQueue <- req:
+		c.enqueuers.Done()
+	case <-quit:
+		c.enqueuers.Done()
+		return ErrClientClosed
+	case <-ctx.Done():
+		c.enqueuers.Done()
+		return ctx.Err()
+	}
+
+	select {
+	case <-req.done:
+		return req.err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushRequest tracks the batches sent for a FlushContext call
+type flushRequest struct {
+	pending int32 // batches not yet sent, plus one until all are handed off
+	done    chan struct{}
+
+	mu   sync.Mutex
+	errs []error
+}
+
+func newFlushRequest() *flushRequest {
+	return &flushRequest{pending: 1, done: make(chan struct{})}
+}
+
+// add counts a batch handed to the senders for the request
+func (r *flushRequest) add() {
+	atomic.AddInt32(&r.pending, 1)
+}
+
+// finish records the outcome of a batch, or the end of the hand-off
+func (r *flushRequest) finish(err error) {
+	if err != nil {
+		r.mu.Lock()
+		r.errs = append(r.errs, err)
+		r.mu.Unlock()
+	}
+
+	if atomic.AddInt32(&r.pending, -1) == 0 {
+		close(r.done)
+	}
+}
+
+func (r *flushRequest) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return combineErrors(r.errs)
 }
 
 //
@@ -193,13 +620,18 @@ func (c *InsertClient) Flush() error {
 // we don't block on EnqueueEvent
 //
 func (c *InsertClient) queueWorker(inputChannel chan interface{}) (err error) {
-	for { //nolint:gosimple
+	for {
 		select {
 		case msg := <-inputChannel:
 			err = c.EnqueueEvent(msg)
+			if errors.Is(err, ErrClientClosed) {
+				return nil
+			}
 			if err != nil {
 				return err
 			}
+		case <-c.quit:
+			return nil
 		}
 	}
 }
@@ -213,55 +645,171 @@ func (c *InsertClient) watchdog() (err error) {
 		return errors.New("invalid timer for watchdog()")
 	}
 
-	for { //nolint:gosimple
+	for {
 		select {
 		case <-c.eventTimer.C:
 			// Timer expired, and we have data, send it
 			atomic.AddInt64(&c.Statistics.TimerExpiredCount, 1)
 			c.Logger.Debug("Timeout expired, flushing queued events")
-			if err = c.Flush(); err != nil {
+			if err = c.Flush(); errors.Is(err, ErrClientClosed) {
+				return nil
+			} else if err != nil {
 				return
 			}
 			c.eventTimer.Reset(c.BatchTime)
+		case <-c.quit:
+			c.eventTimer.Stop()
+			return nil
 		}
 	}
 }
 
 //
 // batchWorker reads []byte from the queue until a threshold is passed,
-// then copies the []byte it has read and sends that batch along to Insights
-// in its own goroutine.
+// either BatchSize events or MaxBatchBytes of payload, then copies the
+// []byte it has read and hands that batch to the senders. Once the client
+// is stopped, whatever is left in the queue is sent before returning.
 //
 func (c *InsertClient) batchWorker() (err error) {
-	eventBuf := make([][]byte, c.BatchSize)
-	count := 0
+	batch := &eventBatch{events: make([][]byte, c.BatchSize)}
 	for {
 		select {
 		case item := <-c.eventQueue:
-			eventBuf[count] = item
-			count++
-			if count >= c.BatchSize {
-				c.grabAndConsumeEvents(count, eventBuf)
-				count = 0
-			}
-		case <-c.flushQueue:
-			if count > 0 {
-				c.grabAndConsumeEvents(count, eventBuf)
-				count = 0
+			c.addToBatch(batch, item)
+		case req := <-c.flushQueue:
+			c.flush(batch, req)
+		case <-c.stop:
+			// Serve the flushes that raced with Close before leaving
+			for {
+				select {
+				case req := <-c.flushQueue:
+					c.flush(batch, req)
+				default:
+					c.drainEvents(batch)
+					return nil
+				}
 			}
 		}
 	}
 }
 
-// grabAndConsumeEvents makes a copy of the event handles,
-// and asynchronously writes those events in its own goroutine.
-// The write is attempted up to c.RetryCount times.
-//
-// TODO: Any errors encountered doing the write are dropped on the floor.
-// Even the last error (in the event of trying c.RetryCount times)
-// is dropped.
+// flush sends the batch being built, along with the events still waiting in
+// the queue. For a FlushContext request, the request follows every batch
+// already handed off and every batch it hands off, until they complete.
+func (c *InsertClient) flush(batch *eventBatch, req *flushRequest) {
+	if req == nil {
+		c.drainEvents(batch)
+		return
+	}
+
+	c.followPendingBatches(req)
+	batch.flush = req
+	c.drainEvents(batch)
+	batch.flush = nil
+	req.finish(nil)
+}
+
+// drainEvents sends everything remaining in the event queue, along with
+// the events already in batch.
+func (c *InsertClient) drainEvents(batch *eventBatch) {
+	for {
+		select {
+		case item := <-c.eventQueue:
+			c.addToBatch(batch, item)
+		default:
+			c.sendBatchNow(batch)
+			return
+		}
+	}
+}
+
+// eventBatch is the batch being built by batchWorker
+type eventBatch struct {
+	events [][]byte
+	count  int
+	bytes  int           // size of the JSON array holding the events
+	flush  *flushRequest // waiting on the batches being sent, if any
+}
+
+// queuedBatch is a batch of events handed to the senders
+type queuedBatch struct {
+	events  [][]byte
+	flushes []*flushRequest // waiting on the batch, guarded by pendingMu
+}
+
+// addToBatch appends an event to the batch, sending the batch first if
+// the event would push it over MaxBatchBytes, and afterwards if it is full.
+func (c *InsertClient) addToBatch(batch *eventBatch, item []byte) {
+	if batch.count > 0 && c.exceedsMaxBatchBytes(batch.bytes+len(item)+1) {
+		c.sendBatchNow(batch)
+	}
+
+	if batch.count == 0 {
+		batch.bytes = 2 // []
+	} else {
+		batch.bytes++ // ,
+	}
+	batch.events[batch.count] = item
+	batch.count++
+	batch.bytes += len(item)
+
+	if batch.count >= c.BatchSize {
+		c.sendBatchNow(batch)
+	}
+}
+
+// sendBatchNow hands whatever is in the batch to the senders
+func (c *InsertClient) sendBatchNow(batch *eventBatch) {
+	if batch.count > 0 {
+		if batch.flush != nil {
+			batch.flush.add()
+		}
+		c.grabAndConsumeEvents(batch.count, batch.events, batch.flush)
+		batch.count = 0
+		batch.bytes = 0
+	}
+}
+
+// exceedsMaxBatchBytes reports whether a JSON payload of size bytes is
+// expected to be larger than MaxBatchBytes once compressed, based on how
+// well previous payloads compressed.
+func (c *InsertClient) exceedsMaxBatchBytes(size int) bool {
+	if c.MaxBatchBytes <= 0 {
+		return false
+	}
+
+	return float64(size)*c.compressionRatio() > float64(c.MaxBatchBytes)
+}
+
+// compressionRatio is the encoded to raw size ratio of the last payload
+func (c *InsertClient) compressionRatio() float64 {
+	bits := atomic.LoadUint64(&c.lastCompressionRatio)
+	if bits == 0 {
+		return 1
+	}
+	return math.Float64frombits(bits)
+}
+
+// recordCompressionRatio keeps track of how well payloads compress, so
+// batches can be cut before they exceed MaxBatchBytes.
+func (c *InsertClient) recordCompressionRatio(raw int, encoded int64) {
+	if raw <= 0 || encoded <= 0 {
+		return
+	}
+
+	ratio := float64(encoded) / float64(raw)
+	if ratio > 1 {
+		ratio = 1
+	}
+	atomic.StoreUint64(&c.lastCompressionRatio, math.Float64bits(ratio))
+}
+
+// grabAndConsumeEvents makes a copy of the event handles, and hands them
+// to the pool of senders. It blocks while all WorkerCount senders are busy
+// and the batch queue is full, which in turn makes EnqueueEvent block
+// instead of piling up goroutines and memory.
 //
-func (c *InsertClient) grabAndConsumeEvents(count int, eventBuf [][]byte) {
+func (c *InsertClient) grabAndConsumeEvents(count int, eventBuf [][]byte, flush *flushRequest) {
 	if count < c.BatchSize-20 {
 		atomic.AddInt64(&c.Statistics.PartialFlushCount, 1) // Allow for some fuzz, although there should be none
 	} else {
@@ -274,70 +822,210 @@ func (c *InsertClient) grabAndConsumeEvents(count int, eventBuf [][]byte) {
 		eventBuf[i] = nil
 	}
 
-	go func(count int, saved [][]byte) {
-		// only send the slice that we pulled into the buffer
-		for tries := 0; tries < c.RetryCount; tries++ {
-			if sendErr := c.sendEvents(saved[0:count]); sendErr != nil {
-				if tries+1 >= c.RetryCount {
-					//failed last retry
-					c.Logger.Errorf("Failed to send insights events [%d/%d] times. Retry limit reached -- Abandoning data. Error: %v",
-						tries+1, c.RetryCount, sendErr)
-				} else {
-					c.Logger.Errorf("Failed to send insights events [%d/%d]. Will retry. Error: %v", tries+1, c.RetryCount, sendErr)
-					atomic.AddInt64(&c.Statistics.InsightsRetryCount, 1)
-					time.Sleep(c.RetryWait)
-				}
-			} else {
-				break
-			}
+	queued := &queuedBatch{events: saved}
+	if flush != nil {
+		queued.flushes = []*flushRequest{flush}
+	}
+
+	c.pendingMu.Lock()
+	if c.pendingBatches == nil {
+		c.pendingBatches = make(map[*queuedBatch]struct{})
+	}
+	c.pendingBatches[queued] = struct{}{}
+	c.pendingMu.Unlock()
+
+	atomic.AddInt64(&c.Statistics.WaitingBatchCount, 1)
+	c.batchQueue <- queued
+}
+
+// followPendingBatches makes req wait for every batch already handed to the
+// senders, queued or in flight
+func (c *InsertClient) followPendingBatches(req *flushRequest) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for batch := range c.pendingBatches {
+		req.add()
+		batch.flushes = append(batch.flushes, req)
+	}
+}
+
+// finishBatch reports the outcome of a batch to the flush requests waiting
+// on it
+func (c *InsertClient) finishBatch(batch *queuedBatch, err error) {
+	c.pendingMu.Lock()
+	delete(c.pendingBatches, batch)
+	flushes := batch.flushes
+	batch.flushes = nil
+	c.pendingMu.Unlock()
+
+	for _, req := range flushes {
+		req.finish(err)
+	}
+}
+
+// sendWorker sends batches from the batch queue until it is closed, giving
+// up on retries once ctx is done
+func (c *InsertClient) sendWorker(ctx context.Context) {
+	for batch := range c.batchQueue {
+		atomic.AddInt64(&c.Statistics.WaitingBatchCount, -1)
+		atomic.AddInt64(&c.Statistics.InFlightBatchCount, 1)
+		err := c.sendBatch(ctx, batch.events)
+		atomic.AddInt64(&c.Statistics.InFlightBatchCount, -1)
+		c.releaseBytes(batchBytes(batch.events))
+		c.finishBatch(batch, err)
+	}
+}
+
+// sendBatch writes a batch of events to Insights, retrying according to the
+// RetryPolicy, after which the batch is spooled to disk if enabled, or
+// reported to OnError as a *BatchError. Batches rejected for being too large
+// are split in half and each half is sent on its own. It returns the error
+// of the batch if it could not be sent, even if it was spooled. Once ctx is
+// done, the batch is no longer retried.
+func (c *InsertClient) sendBatch(ctx context.Context, events [][]byte) error {
+	attempts, sendErr := c.retry(ctx, func() error {
+		return c.sendEvents(ctx, events)
+	}, func(attempt int, wait time.Duration, err error) {
+		c.Logger.Errorf("Failed to send insights events [attempt %d]. Will retry in %s. Error: %v", attempt, wait, err)
+		atomic.AddInt64(&c.Statistics.InsightsRetryCount, 1)
+	})
+
+	if statusCode(sendErr) == http.StatusRequestEntityTooLarge && len(events) > 1 {
+		c.Logger.Debugf("Batch of %d insights events is too large, splitting it", len(events))
+		atomic.AddInt64(&c.Statistics.SplitBatchCount, 1)
+		half := len(events) / 2
+		return combineErrors([]error{c.sendBatch(ctx, events[:half]), c.sendBatch(ctx, events[half:])})
+	}
+
+	if sendErr != nil && !(spoolable(sendErr) && c.spoolBatch(events)) {
+		c.Logger.Errorf("Failed to send insights events [%d] times. Retry limit reached -- Abandoning data. Error: %v",
+			attempts, sendErr)
+		batchErr := &BatchError{
+			Events:     events,
+			Attempts:   attempts,
+			StatusCode: statusCode(sendErr),
+			Err:        sendErr,
 		}
-		atomic.AddInt64(&c.Statistics.ProcessedEventCount, int64(count))
-	}(count, saved)
+		c.recordShutdownError(batchErr)
+		c.reportError(batchErr)
+		sendErr = batchErr
+	}
+	atomic.AddInt64(&c.Statistics.ProcessedEventCount, int64(len(events)))
+	return sendErr
+}
+
+// reportError hands errors from the background workers to OnError, or
+// logs them if no handler is set.
+func (c *InsertClient) reportError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+		return
+	}
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) { // Already logged by the sender
+		c.Logger.Error(err)
+	}
+}
+
+// recordShutdownError keeps track of batches abandoned while the client is
+// closing, so they can be reported by Close.
+func (c *InsertClient) recordShutdownError(err error) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.closed {
+		c.shutdownErrs = append(c.shutdownErrs, err)
+	}
 }
 
 // sendEvents accepts a slice of marshalled JSON and sends it to Insights
 //
-func (c *InsertClient) sendEvents(events [][]byte) error {
-	var buf bytes.Buffer
-
-	// Since we already marshalled all of the data into JSON, let's make a
-	// hand-crafted, artisanal JSON array
-	buf.WriteString("[")
-	eventCount := len(events) - 1
-	for e := range events {
-		buf.Write(events[e])
-		if e < eventCount {
-			buf.WriteString(",")
+func (c *InsertClient) sendEvents(ctx context.Context, events [][]byte) error {
+	// Since we already marshalled all of the data into JSON, the array is
+	// written straight into the request body
+	body := jsonArray(events)
+	return c.post(ctx, events, body, func() {
+		atomic.AddInt64(&c.Statistics.ByteCount, int64(body.size()))
+	})
+}
+
+// post sends the request body holding events to Insights, once the circuit
+// breaker and the rate limits allow it, and records its outcome. sending,
+// if not nil, is called right before the request is sent.
+func (c *InsertClient) post(ctx context.Context, events [][]byte, body requestBody, sending func()) error {
+	var probe bool
+	breaker := c.circuitBreaker()
+	if breaker != nil {
+		var err error
+		if probe, err = breaker.allow(time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if err := c.waitForRateLimit(ctx, events); err != nil {
+		// Nothing was sent, so this says nothing about Insights
+		if breaker != nil {
+			breaker.release(probe)
 		}
+		return err
+	}
+
+	if sending != nil {
+		sending()
+	}
+
+	start := time.Now()
+	err := c.jsonPostRequest(ctx, body)
+	c.sendMetrics.observe(time.Since(start), err)
+	if err != nil {
+		atomic.AddInt64(&c.Statistics.HTTPErrorCount, 1)
 	}
-	buf.WriteString("]")
-	atomic.AddInt64(&c.Statistics.ByteCount, int64(buf.Len()))
 
-	return c.jsonPostRequest(buf.Bytes())
+	if breaker != nil {
+		breaker.done(time.Now(), probe, err)
+	}
+	return err
 }
 
-// SetCompression allows modification of the compression type used in communication
+// SetCompression allows modification of the compression type used in
+// communication. CompressionLevel must be set beforehand, as it is checked
+// along with compression.
 //
-func (c *InsertClient) SetCompression(compression Compression) {
-	c.Compression = Gzip
-	// use gzip only for now
-	// c.Compression = compression
-	log.Debugf("Compression set: %d", c.Compression)
+func (c *InsertClient) SetCompression(compression Compression) error {
+	switch compression {
+	case None:
+	case Deflate, Gzip, Zlib:
+		if err := checkCompressionLevel(c.CompressionLevel); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported compression: %s", compression)
+	}
+
+	c.Compression = compression
+	c.Logger.Debugf("Compression set: %s", c.Compression)
+	return nil
 }
 
-func (c *InsertClient) jsonPostRequest(body []byte) (err error) {
-	const prependText = "Insights Post: "
+func (c *InsertClient) jsonPostRequest(ctx context.Context, body requestBody) (err error) {
+	const prependText = "Insights Post"
 
-	req, reqErr := c.generateJSONPostRequest(body)
+	req, release, reqErr := c.generateJSONPostRequest(body)
 	if reqErr != nil {
-		return fmt.Errorf("%s: %v", prependText, reqErr)
+		return fmt.Errorf("%s: %w", prependText, reqErr)
 	}
+	// GetBody may be called until Do returns
+	defer release()
 
-	ctx, cancel := context.WithTimeout(req.Context(), c.RequestTimeout)
+	c.recordCompressionRatio(body.size(), req.ContentLength)
+
+	ctx, cancel := c.requestContext(ctx)
 	defer cancel()
-	resp, respErr := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, respErr := c.httpClient().Do(req.WithContext(ctx))
 	if respErr != nil {
-		return fmt.Errorf("%s: %v", prependText, respErr)
+		return fmt.Errorf("%s: %w", prependText, respErr)
 	}
 	defer func() {
 		respErr = resp.Body.Close()
@@ -347,48 +1035,58 @@ func (c *InsertClient) jsonPostRequest(body []byte) (err error) {
 	}()
 
 	if parseErr := c.parseResponse(resp); parseErr != nil {
-		return fmt.Errorf("%s: %v", prependText, parseErr)
+		return fmt.Errorf("%s: %w", prependText, parseErr)
 	}
 
 	return nil
 }
 
-func (c *InsertClient) generateJSONPostRequest(body []byte) (request *http.Request, err error) {
-	var readBuffer io.Reader
+// generateJSONPostRequest builds the request sending body, encoded and
+// compressed straight into a pooled buffer. The buffer backs the request
+// body, as well as the copies made by GetBody when the request is retried or
+// redirected. It goes back to the pool once every body has been closed and
+// the returned release func has been called, which must not happen before
+// the request is done.
+func (c *InsertClient) generateJSONPostRequest(body requestBody) (*http.Request, func(), error) {
 	var encoding string
 
-	switch c.Compression {
+	compression := c.Compression
+	if compression != None && body.size() < c.MinCompressionBytes {
+		compression = None
+	}
+
+	c.Logger.Debugf("Compression: %s", compression)
+	switch compression {
 	case None:
-		c.Logger.Debug("Compression: None")
-		readBuffer = bytes.NewBuffer(body)
-	case Deflate:
-		c.Logger.Debug("Compression: Deflate")
-		readBuffer = nil
-	case Gzip:
-		c.Logger.Debug("Compression: Gzip")
-		readBuffer, err = gZipBuffer(body)
-		encoding = "gzip"
-	case Zlib:
-		c.Logger.Debug("Compression: Zlib")
-		readBuffer = nil
+	case Deflate, Gzip, Zlib:
+		encoding = compression.contentEncoding()
+	default:
+		return nil, nil, fmt.Errorf("failed to read body: unsupported compression: %s", compression)
 	}
 
+	buf, err := encodeBody(body, compression, c.CompressionLevel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read body: %v", err)
+		return nil, nil, fmt.Errorf("failed to read body: %w", err)
 	}
 
-	request, err = http.NewRequest("POST", c.URL.String(), readBuffer)
+	request, err := http.NewRequest("POST", c.URL.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct request for: %s", body)
+		putBuffer(buf)
+		return nil, nil, fmt.Errorf("failed to construct request: %w", err)
 	}
 
+	pooled := newPooledBuffer(buf)
+	request.Body = pooled.newBody()
+	request.GetBody = func() (io.ReadCloser, error) { return pooled.newBody(), nil }
+	request.ContentLength = int64(buf.Len())
+
 	request.Header.Add("Content-Type", "application/json")
 	request.Header.Add("X-Insert-Key", c.InsertKey)
 	if encoding != "" {
 		request.Header.Add("Content-Encoding", encoding)
 	}
 
-	return request, nil
+	return request, pooled.release, nil
 }
 
 // parseResponse checks the Insert response for errors and reports the message
@@ -396,18 +1094,18 @@ func (c *InsertClient) generateJSONPostRequest(body []byte) (request *http.Reque
 func (c *InsertClient) parseResponse(response *http.Response) error {
 	body, readErr := ioutil.ReadAll(response.Body)
 	if readErr != nil {
-		return fmt.Errorf("failed to read response body: %s", readErr.Error())
+		return fmt.Errorf("failed to read response body: %w", readErr)
 	}
 
 	if response.StatusCode != 200 {
-		return fmt.Errorf("bad response from Insights: %d \n\t%s", response.StatusCode, string(body))
+		return newAPIError(response, body)
 	}
 
 	c.Logger.Debugf("Response %d body: %s", response.StatusCode, body)
 
 	respJSON := insertResponse{}
 	if err := json.Unmarshal(body, &respJSON); err != nil {
-		return fmt.Errorf("failed to unmarshal insights response: %v", err)
+		return fmt.Errorf("failed to unmarshal insights response: %w", err)
 	}
 
 	// Success
@@ -416,9 +1114,9 @@ func (c *InsertClient) parseResponse(response *http.Response) error {
 	}
 
 	// Non 200 response (or 200 not success, if such a thing)
-	if respJSON.Error == "" {
-		respJSON.Error = "Error unknown"
+	apiErr := newAPIError(response, body)
+	if apiErr.Message == "" {
+		apiErr.Message = "Error unknown"
 	}
-
-	return fmt.Errorf("%d: %s", response.StatusCode, respJSON.Error)
+	return apiErr
 }
@@ -4,9 +4,15 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,29 +35,58 @@ func TestGenerateJSONPostRequest(t *testing.T) {
 
 	// compression: None
 	client.Compression = None
-	req, err = client.generateJSONPostRequest(testInsertJSON[0])
+	req, _, err = client.generateJSONPostRequest(jsonDocument(testInsertJSON[0]))
 	assert.NoError(t, err)
 	assert.NotNil(t, req)
 
 	// compression: Deflate
 	client.Compression = Deflate
-	req, err = client.generateJSONPostRequest(testInsertJSON[0])
+	req, _, err = client.generateJSONPostRequest(jsonDocument(testInsertJSON[0]))
 	assert.NoError(t, err)
 	assert.NotNil(t, req)
 
 	// compression: Gzip
 	client.Compression = Gzip
-	req, err = client.generateJSONPostRequest(testInsertJSON[0])
+	req, _, err = client.generateJSONPostRequest(jsonDocument(testInsertJSON[0]))
 	assert.NoError(t, err)
 	assert.NotNil(t, req)
 
 	// compression: Zlib
 	client.Compression = Zlib
-	req, err = client.generateJSONPostRequest(testInsertJSON[0])
+	req, _, err = client.generateJSONPostRequest(jsonDocument(testInsertJSON[0]))
 	assert.NoError(t, err)
 	assert.NotNil(t, req)
 }
 
+func TestGenerateJSONPostRequest_encoding(t *testing.T) {
+	body := []byte(testInsertJSONString)
+	client := NewInsertClient(testKey, testID)
+
+	for compression, encoding := range map[Compression]string{None: "", Deflate: "deflate", Gzip: "gzip", Zlib: "deflate"} {
+		client.Compression = compression
+		req, _, err := client.generateJSONPostRequest(jsonDocument(body))
+		assert.NoError(t, err)
+		assert.Equal(t, encoding, req.Header.Get("Content-Encoding"), "Wrong encoding for %s", compression)
+
+		decoded, err := decompress(req.Body, compression)
+		assert.NoError(t, err)
+		assert.Equal(t, body, decoded, "Body should round trip with %s", compression)
+	}
+
+	// Small bodies are not worth compressing
+	client.Compression = Gzip
+	client.MinCompressionBytes = len(body) + 1
+	req, _, err := client.generateJSONPostRequest(jsonDocument(body))
+	assert.NoError(t, err)
+	assert.Equal(t, "", req.Header.Get("Content-Encoding"))
+
+	// Invalid levels are reported
+	client.MinCompressionBytes = 0
+	client.CompressionLevel = 42
+	_, _, err = client.generateJSONPostRequest(jsonDocument(body))
+	assert.Error(t, err)
+}
+
 // Successful Insert
 func TestJSONPostRequest_success(t *testing.T) {
 	var err error
@@ -65,7 +100,38 @@ func TestJSONPostRequest_success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	err = client.jsonPostRequest(testInsertJSON[0])
+	err = client.jsonPostRequest(context.Background(), jsonDocument(testInsertJSON[0]))
+	assert.NoError(t, err)
+}
+
+// Redirected Insert, which needs the body to be sent again
+func TestJSONPostRequest_redirect(t *testing.T) {
+	var err error
+	body := []byte(`{"eventType":"test"}`)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/moved" {
+			http.Redirect(w, r, "/moved", http.StatusTemporaryRedirect)
+			return
+		}
+		received, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, body, received, "The body should be sent again")
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	req, release, err := client.generateJSONPostRequest(jsonDocument(body))
+	assert.NoError(t, err)
+	assert.NotNil(t, req.GetBody, "Requests must be replayable")
+	assert.Equal(t, int64(len(body)), req.ContentLength)
+	assert.NoError(t, req.Body.Close())
+	release()
+
+	err = client.jsonPostRequest(context.Background(), jsonDocument(body))
 	assert.NoError(t, err)
 }
 
@@ -82,7 +148,7 @@ func TestJSONPostRequest_failure(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	err = client.jsonPostRequest(testInsertJSON[0])
+	err = client.jsonPostRequest(context.Background(), jsonDocument(testInsertJSON[0]))
 	assert.Error(t, err)
 }
 
@@ -98,7 +164,7 @@ func TestJSONPostRequest_badresponse(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	err = client.jsonPostRequest(testInsertJSON[0])
+	err = client.jsonPostRequest(context.Background(), jsonDocument(testInsertJSON[0]))
 	assert.Error(t, err)
 }
 
@@ -119,7 +185,7 @@ func TestJSONPostRequest_timeout(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	err = client.jsonPostRequest(testInsertJSON[0])
+	err = client.jsonPostRequest(context.Background(), jsonDocument(testInsertJSON[0]))
 	assert.Error(t, err)
 }
 
@@ -171,7 +237,7 @@ func TestInsertSendEvents(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	err = client.sendEvents(testInsertJSON)
+	err = client.sendEvents(context.Background(), testInsertJSON)
 	assert.NoError(t, err)
 }
 
@@ -189,7 +255,13 @@ func TestInsertGrabAndConsumeEvents_partial(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
 
-	client.grabAndConsumeEvents(len(testData)-1, testData)
+	client.batchQueue = make(chan *queuedBatch, 1)
+	client.grabAndConsumeEvents(len(testData)-1, testData, nil)
+
+	batch := <-client.batchQueue
+	assert.Equal(t, len(testData)-1, len(batch.events))
+	assert.Equal(t, int64(1), client.Statistics.PartialFlushCount)
+	assert.Equal(t, int64(1), client.Statistics.WaitingBatchCount)
 }
 
 func TestInsertGrabAndConsumeEvents_fullBatch(t *testing.T) {
@@ -207,7 +279,12 @@ func TestInsertGrabAndConsumeEvents_fullBatch(t *testing.T) {
 	assert.Equal(t, ts.URL, client.URL.String())
 
 	client.BatchSize = len(testData) - 1
-	client.grabAndConsumeEvents(len(testData)-1, testData)
+	client.batchQueue = make(chan *queuedBatch, 1)
+	client.grabAndConsumeEvents(len(testData)-1, testData, nil)
+
+	batch := <-client.batchQueue
+	assert.Equal(t, len(testData)-1, len(batch.events))
+	assert.Equal(t, int64(1), client.Statistics.FullFlushCount)
 }
 
 func TestInsertPostEvent(t *testing.T) {
@@ -295,8 +372,9 @@ func TestNewInsertClientEnqueueEvent_good(t *testing.T) {
 	client.eventQueue = make(chan []byte, client.BatchSize)
 
 	event := struct {
-		Test int
-	}{1}
+		EventType string `json:"eventType"`
+		Test      int
+	}{"test", 1}
 	err := client.EnqueueEvent(event)
 	assert.NoError(t, err)
 }
@@ -308,8 +386,9 @@ func TestNewInsertClientEnqueueEventContext_good(t *testing.T) {
 	client.eventQueue = make(chan []byte, 1)
 
 	event := struct {
-		Test int
-	}{1}
+		EventType string `json:"eventType"`
+		Test      int
+	}{"test", 1}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
 	defer cancel()
 	err := client.EnqueueEventContext(ctx, event)
@@ -323,8 +402,9 @@ func TestNewInsertClientEnqueueEventContext_timeout(t *testing.T) {
 	client.eventQueue = make(chan []byte)
 
 	event := struct {
-		Test int
-	}{1}
+		EventType string `json:"eventType"`
+		Test      int
+	}{"test", 1}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
 	defer cancel()
 	err := client.EnqueueEventContext(ctx, event)
@@ -335,7 +415,7 @@ func TestNewInsertClientFlush_good(t *testing.T) {
 	client := NewInsertClient(testKey, testID)
 
 	assert.NotNil(t, client)
-	client.flushQueue = make(chan bool, client.WorkerCount)
+	client.flushQueue = make(chan *flushRequest, client.WorkerCount)
 
 	err := client.Flush()
 	assert.NoError(t, err)
@@ -396,12 +476,31 @@ func TestNewInsertClientSetCompression(t *testing.T) {
 
 	assert.NotNil(t, client)
 
-	client.SetCompression(Gzip)
+	err := client.SetCompression(Gzip)
+	assert.NoError(t, err)
 	assert.Equal(t, Gzip, client.Compression)
 
-	// We only support Gzip right now...
-	client.SetCompression(None)
-	assert.Equal(t, Gzip, client.Compression)
+	for _, compression := range []Compression{None, Deflate, Zlib} {
+		err = client.SetCompression(compression)
+		assert.NoError(t, err)
+		assert.Equal(t, compression, client.Compression)
+	}
+
+	// Unknown compression types are rejected
+	err = client.SetCompression(Compression(42))
+	assert.Error(t, err)
+	assert.Equal(t, Zlib, client.Compression)
+
+	// So are invalid compression levels
+	client.CompressionLevel = 42
+	err = client.SetCompression(Gzip)
+	assert.Error(t, err)
+	assert.Equal(t, Zlib, client.Compression)
+	assert.Error(t, client.Validate())
+	assert.Error(t, client.Start())
+
+	assert.NoError(t, client.SetCompression(None))
+	assert.NoError(t, client.Validate())
 }
 
 /************************************************
@@ -424,15 +523,17 @@ func TestInsertBatchWorker(t *testing.T) {
 	client.BatchSize = len(testData) - 1
 
 	client.eventQueue = make(chan []byte, 1)
-	client.flushQueue = make(chan bool, 1)
+	client.batchQueue = make(chan *queuedBatch, 1)
+	client.flushQueue = make(chan *flushRequest, 1)
 
 	go func() {
 		err := client.batchWorker()
 		assert.NoError(t, err)
 	}()
+	go client.sendWorker(context.Background())
 
 	for e := range testData {
-		err = client.EnqueueEvent(e)
+		err = client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": e})
 		assert.NoError(t, err)
 	}
 
@@ -482,17 +583,17 @@ func TestInsertWatchdog_good(t *testing.T) {
 	client.BatchTime = 1 * time.Nanosecond                   // Start with a low timeout
 	client.eventTimer = time.NewTimer(100 * time.Nanosecond) // Add a timer
 	client.eventQueue = make(chan []byte, 1)                 // Needed for Flush()
-	client.flushQueue = make(chan bool, 10)                  // Make it large enough that we aren't blocking
+	client.flushQueue = make(chan *flushRequest, 10)         // Make it large enough that we aren't blocking
 
 	// This should not fail, expire, and reset the timer to the default
 	client.BatchTime = DefaultBatchTimeout
 	go func() {
-		err = client.watchdog()
+		err := client.watchdog()
 		assert.NoError(t, err)
 	}()
 	time.Sleep(2 * time.Second)
 
-	assert.Equal(t, int64(1), client.Statistics.TimerExpiredCount, "Timer should of expired once")
+	assert.Equal(t, int64(1), client.Snapshot().TimerExpiredCount, "Timer should of expired once")
 }
 
 func TestInsertQueueWorker(t *testing.T) {
@@ -553,3 +654,710 @@ func TestInsertStartListener(t *testing.T) {
 	err = client.StartListener(testChan)
 	assert.NoError(t, err)
 }
+
+func TestInsertClose_drainsQueue(t *testing.T) {
+	var err error
+	var received int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		atomic.AddInt64(&received, int64(len(events)))
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.BatchSize = 3
+
+	err = client.Start()
+	assert.NoError(t, err)
+
+	for x := 0; x < 10; x++ {
+		err = client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": x})
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Close(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), atomic.LoadInt64(&received), "All queued events should be sent on Close")
+	assert.Equal(t, int64(10), atomic.LoadInt64(&client.Statistics.ProcessedEventCount))
+
+	// Closed clients refuse new work
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.Equal(t, ErrClientClosed, err)
+	err = client.Flush()
+	assert.Equal(t, ErrClientClosed, err)
+	err = client.Close(ctx)
+	assert.Equal(t, ErrClientClosed, err)
+
+	// ...but may be restarted
+	err = client.Start()
+	assert.NoError(t, err)
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.NoError(t, err)
+	err = client.Close(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), atomic.LoadInt64(&received))
+}
+
+func TestInsertClose_failedBatches(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryCount = 1
+
+	err = client.Start()
+	assert.NoError(t, err)
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.NoError(t, err)
+
+	err = client.Close(context.Background())
+	assert.Error(t, err, "Close should report batches that could not be sent")
+}
+
+func TestInsertClose_deadline(t *testing.T) {
+	var err error
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	err = client.Start()
+	assert.NoError(t, err)
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.Close(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Close should honor the context deadline")
+
+	// The request in flight is cancelled, so the client can be restarted
+	// without waiting for Insights
+	waitForRestart(t, client)
+	assert.NoError(t, client.Close(context.Background()))
+}
+
+func TestInsertClose_deadlineRetries(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryWait = time.Hour
+
+	errs := make(chan error, 1)
+	client.OnError = func(err error) { errs <- err }
+
+	err = client.Start()
+	assert.NoError(t, err)
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.Close(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Close should honor the context deadline")
+
+	select {
+	case err = <-errs:
+		var batchErr *BatchError
+		assert.True(t, errors.As(err, &batchErr), "Abandoned batches are reported")
+		assert.Equal(t, 1, batchErr.Attempts, "Retries stop once Close gives up")
+	case <-time.After(5 * time.Second):
+		t.Fatal("The retry backoff was not cancelled")
+	}
+	waitForRestart(t, client)
+	assert.NoError(t, client.Close(context.Background()))
+}
+
+// waitForRestart starts the client again, once it is done shutting down
+func waitForRestart(t *testing.T, client *InsertClient) {
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = client.Start(); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The client could not be restarted: %v", err)
+}
+
+func TestInsertClose_notStarted(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+
+	err := client.Close(context.Background())
+	assert.Error(t, err)
+}
+
+func TestInsertOnError_batchError(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryCount = 2
+	client.RetryWait = time.Millisecond
+
+	errs := make(chan error, 1)
+	client.OnError = func(err error) { errs <- err }
+
+	event := []byte(testInsertJSONString)
+	client.sendBatch(context.Background(), [][]byte{event, event})
+
+	select {
+	case err = <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError was not called")
+	}
+
+	var batchErr *BatchError
+	assert.True(t, errors.As(err, &batchErr))
+	assert.Equal(t, 2, batchErr.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, batchErr.StatusCode)
+	assert.Equal(t, 2, len(batchErr.Events))
+	assert.Equal(t, event, batchErr.Events[0])
+	assert.Error(t, batchErr.Err)
+}
+
+func TestInsertSendWorkers_bounded(t *testing.T) {
+	var err error
+	var active, maxActive int32
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&active, -1)
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.BatchSize = 1
+	client.WorkerCount = 2
+
+	assert.NoError(t, client.Start())
+
+	// 2 batches in flight, 2 waiting in the batch queue, 1 held by the
+	// batch worker and 1 in the event queue
+	for x := 0; x < 6; x++ {
+		err = client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": x})
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.EnqueueEventContext(ctx, map[string]interface{}{"eventType": "test"})
+	assert.Equal(t, context.DeadlineExceeded, err, "Enqueue should block while all senders are busy")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&client.Statistics.InFlightBatchCount))
+	// Including the batch the batch worker is trying to hand off
+	assert.Equal(t, int64(3), atomic.LoadInt64(&client.Statistics.WaitingBatchCount))
+
+	close(release)
+	assert.NoError(t, client.Close(context.Background()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxActive), "No more than WorkerCount requests should be in flight")
+	assert.Equal(t, int64(0), atomic.LoadInt64(&client.Statistics.InFlightBatchCount))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&client.Statistics.WaitingBatchCount))
+	assert.Equal(t, int64(6), atomic.LoadInt64(&client.Statistics.ProcessedEventCount))
+}
+
+func TestInsertAddToBatch_maxBatchBytes(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.BatchSize = 10
+	client.batchQueue = make(chan *queuedBatch, 10)
+
+	event := []byte(testInsertJSONString)
+	// Room for exactly 3 events: [e,e,e]
+	client.MaxBatchBytes = 3*len(event) + 4
+
+	batch := &eventBatch{events: make([][]byte, client.BatchSize)}
+	for x := 0; x < 7; x++ {
+		client.addToBatch(batch, event)
+	}
+	client.sendBatchNow(batch)
+
+	assert.Equal(t, 3, len((<-client.batchQueue).events))
+	assert.Equal(t, 3, len((<-client.batchQueue).events))
+	assert.Equal(t, 1, len((<-client.batchQueue).events))
+
+	// Batches are cut later once payloads are known to compress well
+	client.recordCompressionRatio(100, 50)
+	for x := 0; x < 7; x++ {
+		client.addToBatch(batch, event)
+	}
+	assert.Equal(t, 6, len((<-client.batchQueue).events))
+}
+
+func TestInsertSendBatch_tooLarge(t *testing.T) {
+	var err error
+	var requests int32
+
+	// Only accept a single event per request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var events []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		if len(events) > 1 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.OnError = func(err error) { t.Errorf("Split batches should all be sent: %v", err) }
+
+	event := []byte(testInsertJSONString)
+	client.sendBatch(context.Background(), [][]byte{event, event, event, event})
+
+	// 4 events -> 2+2 -> 1+1+1+1
+	assert.Equal(t, int32(7), atomic.LoadInt32(&requests))
+	assert.Equal(t, int64(3), client.Statistics.SplitBatchCount)
+	assert.Equal(t, int64(4), client.Statistics.ProcessedEventCount)
+}
+
+func TestNewInsertClientEnqueueEvent_tooLarge(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.eventQueue = make(chan []byte, 1)
+	client.MaxBatchBytes = 100
+
+	err := client.EnqueueEvent(map[string]interface{}{"eventType": "test", "str": strings.Repeat("x", 100)})
+	assert.True(t, errors.Is(err, ErrEventTooLarge), "Oversize events should be rejected: %v", err)
+
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test"})
+	assert.NoError(t, err)
+	<-client.eventQueue
+
+	// The limit applies after compression
+	client.recordCompressionRatio(100, 25)
+	err = client.EnqueueEvent(map[string]interface{}{"eventType": "test", "str": strings.Repeat("x", 100)})
+	assert.NoError(t, err, "Events that compress to less than MaxBatchBytes should be accepted")
+}
+
+func TestNewInsertClientEnqueueEvent_unmarshalable(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.eventQueue = make(chan []byte, 1)
+
+	err := client.EnqueueEvent(map[string]interface{}{"eventType": "test", "ch": make(chan int)})
+	var typeErr *json.UnsupportedTypeError
+	assert.True(t, errors.As(err, &typeErr), "The marshaling error should be wrapped: %v", err)
+}
+
+func TestNewInsertClientEnqueueEvent_invalid(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.eventQueue = make(chan []byte, 1)
+
+	event := map[string]interface{}{"noEventType": true}
+
+	err := client.EnqueueEvent(event)
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, RuleEventType, validationErr.Violations[0].Rule)
+
+	client.ValidationMode = ValidationWarn
+	err = client.EnqueueEvent(event)
+	assert.NoError(t, err, "Invalid events should only be logged")
+	assert.Equal(t, 1, len(client.eventQueue))
+}
+
+func TestInsertPostEvent_fixed(t *testing.T) {
+	var err error
+	var received map[string]interface{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	// Looks like it has an eventType, but doesn't
+	err = client.PostEvent(`{"foo": "eventType"}`)
+	assert.Error(t, err)
+
+	testData := map[string]interface{}{
+		"eventType": "test",
+		"nested":    map[string]interface{}{"a": 1},
+		"accountId": 1,
+	}
+	err = client.PostEvent(testData)
+	assert.Error(t, err)
+
+	client.ValidationMode = ValidationFix
+	err = client.PostEvent(testData)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"eventType": "test", "nested": `{"a":1}`}, received)
+}
+
+func TestInsertFlushContext(t *testing.T) {
+	var err error
+	var received int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		time.Sleep(50 * time.Millisecond) // Make sure FlushContext waits for the send
+		atomic.AddInt64(&received, int64(len(events)))
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.BatchSize = 3
+	client.WorkerCount = 2
+
+	assert.Equal(t, "queueing not enabled for this client", client.FlushContext(context.Background()).Error())
+
+	assert.NoError(t, client.Start())
+	defer client.Close(context.Background())
+
+	for x := 0; x < 7; x++ {
+		assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": x}))
+	}
+
+	assert.NoError(t, client.FlushContext(context.Background()))
+	assert.Equal(t, int64(7), atomic.LoadInt64(&received), "Every enqueued event should be sent when FlushContext returns")
+
+	// Nothing left to send
+	assert.NoError(t, client.FlushContext(context.Background()))
+}
+
+func TestInsertFlushContext_failed(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.OnError = func(error) {}
+
+	assert.NoError(t, client.Start())
+	defer client.Close(context.Background())
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test"}))
+
+	err = client.FlushContext(context.Background())
+	var batchErr *BatchError
+	assert.True(t, errors.As(err, &batchErr), "Failed batches should be returned")
+	assert.Equal(t, http.StatusBadRequest, batchErr.StatusCode)
+}
+
+func TestInsertFlushContext_inFlight(t *testing.T) {
+	var err error
+
+	received, release := make(chan struct{}, 1), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.BatchSize = 2
+	client.WorkerCount = 2
+	client.OnError = func(error) {}
+
+	assert.NoError(t, client.Start())
+	defer client.Close(context.Background())
+
+	// A full batch is cut and in flight, the current batch is empty
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": 1}))
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": 2}))
+	<-received
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- client.FlushContext(context.Background()) }()
+
+	select {
+	case err = <-flushed:
+		t.Fatalf("FlushContext returned while a batch was in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	err = <-flushed
+	var batchErr *BatchError
+	assert.True(t, errors.As(err, &batchErr), "The in-flight batch should be waited for: %v", err)
+	if batchErr != nil {
+		assert.Equal(t, http.StatusBadRequest, batchErr.StatusCode)
+	}
+}
+
+func TestInsertFlushContext_cancel(t *testing.T) {
+	var err error
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Start())
+	defer client.Close(context.Background())
+	defer close(release)
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, client.FlushContext(ctx))
+}
+
+func TestInsertPostEventContext_cancel(t *testing.T) {
+	var err error
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.PostEventContext(ctx, map[string]interface{}{"eventType": "test"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "The request should be cancelled: %v", err)
+}
+
+func TestInsertEnqueue_queueFullPolicies(t *testing.T) {
+	first := []byte(`{"eventType":"test","num":1}`)
+	second := []byte(`{"eventType":"test","num":2}`)
+
+	var dropped [][]byte
+	client := NewInsertClient(testKey, testID)
+	client.OnDrop = func(event []byte) { dropped = append(dropped, event) }
+	queue, quit := make(chan []byte, 1), make(chan struct{})
+
+	// Block honors the context
+	assert.NoError(t, client.enqueue(context.Background(), queue, quit, first))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, client.enqueue(ctx, queue, quit, second))
+
+	client.QueueFullPolicy = QueueDropNewest
+	assert.Equal(t, ErrQueueFull, client.enqueue(context.Background(), queue, quit, second))
+	assert.Equal(t, [][]byte{second}, dropped)
+	assert.Equal(t, first, <-queue, "The queued event should be kept")
+
+	queue <- first
+	client.QueueFullPolicy = QueueDropOldest
+	assert.NoError(t, client.enqueue(context.Background(), queue, quit, second))
+	assert.Equal(t, [][]byte{second, first}, dropped)
+	assert.Equal(t, second, <-queue, "The new event should replace the oldest")
+
+	assert.Equal(t, int64(2), client.Statistics.DroppedEventCount)
+}
+
+func TestInsertEnqueue_spillToDisk(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	event := []byte(testInsertJSONString)
+	client := NewInsertClient(testKey, testID)
+	client.QueueFullPolicy = QueueSpillToDisk
+	assert.Error(t, client.Start(), "Spilling requires a spool")
+
+	var err error
+	var dropped int
+	client.BatchSize = 2
+	client.OnDrop = func([]byte) { dropped++ }
+	client.spool, err = openSpool(dir, DefaultSpoolMaxBytes, client.Logger, &client.Statistics)
+	assert.NoError(t, err)
+	client.spillQueue = make(chan []byte, client.BatchSize)
+	client.stop = make(chan struct{})
+
+	queue := make(chan []byte, 1)
+	queue <- event
+	for i := 0; i < 3; i++ {
+		err = client.enqueue(context.Background(), queue, make(chan struct{}), event)
+		if i < client.BatchSize {
+			assert.NoError(t, err, "Spilling should not wait for the disk")
+		} else {
+			assert.Equal(t, ErrQueueFull, err, "The spill queue is bounded")
+		}
+	}
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, 0, client.spool.len(), "Events are written by the spill worker")
+
+	close(client.stop)
+	assert.NoError(t, client.spillWorker())
+	assert.Equal(t, int64(2), client.Statistics.SpilledEventCount)
+	assert.Equal(t, int64(1), client.Statistics.DroppedEventCount)
+	assert.Equal(t, 1, client.spool.len(), "Spilled events are written as a batch")
+
+	_, spilled, err := client.spool.oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{event, event}, spilled)
+}
+
+func TestInsertEnqueue_spillToDiskStarted(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.QueueFullPolicy = QueueSpillToDisk
+	client.SpoolDir = dir
+	client.BatchTime = time.Hour
+	client.MaxBufferedBytes = 1 // Every event but the first is spilled
+	assert.NoError(t, client.Start())
+
+	for i := 0; i < 10; i++ {
+		err := client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": i})
+		if err != nil {
+			assert.Equal(t, ErrQueueFull, err)
+		}
+	}
+	assert.NoError(t, client.Close(context.Background()))
+
+	stats := client.Snapshot()
+	assert.Equal(t, int64(1), stats.ProcessedEventCount)
+	assert.Equal(t, int64(9), stats.SpilledEventCount+stats.DroppedEventCount)
+	assert.True(t, stats.SpilledEventCount > 0)
+	assert.Equal(t, stats.SpooledBatchCount, int64(client.spool.len()))
+}
+
+func TestInsertEnqueue_maxBufferedBytes(t *testing.T) {
+	event := []byte(`{"eventType":"test","num":1}`)
+	size := int64(len(event))
+
+	var dropped int
+	client := NewInsertClient(testKey, testID)
+	client.MaxBufferedBytes = 2 * size
+	client.OnDrop = func([]byte) { dropped++ }
+	queue, quit := make(chan []byte, 10), make(chan struct{})
+
+	assert.NoError(t, client.enqueue(context.Background(), queue, quit, event))
+	assert.NoError(t, client.enqueue(context.Background(), queue, quit, event))
+	assert.Equal(t, 2*size, client.Statistics.BufferedBytes)
+
+	// Block waits for bytes to be released
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, client.enqueue(ctx, queue, quit, event))
+
+	done := make(chan error)
+	go func() { done <- client.enqueue(context.Background(), queue, quit, event) }()
+	<-queue
+	client.releaseBytes(size) // As if it had been sent
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2*size, client.Statistics.BufferedBytes)
+
+	client.QueueFullPolicy = QueueDropNewest
+	assert.Equal(t, ErrQueueFull, client.enqueue(context.Background(), queue, quit, event))
+	assert.Equal(t, 1, dropped)
+
+	client.QueueFullPolicy = QueueDropOldest
+	assert.NoError(t, client.enqueue(context.Background(), queue, quit, event))
+	assert.Equal(t, 2, dropped, "The oldest event should make room")
+	assert.Equal(t, 2, len(queue))
+	assert.Equal(t, 2*size, client.Statistics.BufferedBytes)
+
+	// Nothing left to drop when the budget is held by batches being sent
+	<-queue
+	<-queue
+	assert.Equal(t, ErrQueueFull, client.enqueue(context.Background(), queue, quit, event))
+	assert.Equal(t, 3, dropped)
+}
+
+func TestInsertBufferedBytes(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.BatchSize = 2
+	client.MaxBufferedBytes = 100
+
+	assert.NoError(t, client.Start())
+	for x := 0; x < 10; x++ {
+		assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test", "num": x}))
+		assert.True(t, atomic.LoadInt64(&client.Statistics.BufferedBytes) <= 100, "Buffered bytes should stay within budget")
+	}
+	assert.NoError(t, client.Close(context.Background()))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&client.Statistics.BufferedBytes), "Sent events should release their bytes")
+	assert.Equal(t, int64(10), atomic.LoadInt64(&client.Statistics.ProcessedEventCount))
+}
+
+func TestInsertBufferedBytes_flushesPartialBatch(t *testing.T) {
+	var err error
+
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.MaxBufferedBytes = 100 // Much less than a full batch
+
+	assert.NoError(t, client.Start())
+	defer client.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for x := 0; x < 10; x++ {
+		assert.NoError(t, client.EnqueueEventContext(ctx, map[string]interface{}{"eventType": "test", "num": x}))
+	}
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStructDepth limits how deep nested structs are flattened
+const maxStructDepth = 8
+
+// EventTyper is implemented by structs that know their Insights eventType
+type EventTyper interface {
+	EventType() string
+}
+
+// fieldKind is how a struct field is encoded
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	boolField
+	intField
+	uintField
+	floatField
+	timeField
+	durationField
+)
+
+// structField describes a struct field holding an attribute value
+type structField struct {
+	name      string // dotted for nested structs
+	index     []int
+	kind      fieldKind
+	omitEmpty bool
+}
+
+// structInfo is the cached encoding metadata of a struct type
+type structInfo struct {
+	tagged         bool // any field has an insights tag
+	eventTypeField []int
+	fields         []structField
+	err            error
+}
+
+var (
+	structInfoCache sync.Map // reflect.Type -> *structInfo
+
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// eventFromStruct converts a struct using the insights tag conventions into
+// an Event. Fields are named by their `insights:"name,omitempty"` tag, their
+// json tag when they have no insights tag, or their Go name when untagged,
+// and `insights:"-"` skips a field. The
+// eventType comes from the EventType() method if implemented, or from a
+// string field named eventType. time.Time values are encoded in milliseconds
+// since the epoch, time.Duration values in (fractional) seconds, unsigned
+// values too large for an int64 as floats, and nested structs are flattened
+// into dotted names like "request.method".
+//
+// It reports false for anything that isn't such a struct, which includes
+// structs with neither insights tags nor an EventType method.
+func eventFromStruct(data interface{}) (*Event, bool, error) {
+	value := reflect.ValueOf(data)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, false, nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct || value.Type() == timeType {
+		return nil, false, nil
+	}
+
+	typer, isTyper := data.(EventTyper)
+	info := cachedStructInfo(value.Type())
+	if !info.tagged && !isTyper {
+		return nil, false, nil
+	}
+	if info.err != nil {
+		return nil, true, info.err
+	}
+
+	var eventType string
+	switch {
+	case isTyper:
+		eventType = typer.EventType()
+	case info.eventTypeField != nil:
+		eventType = value.FieldByIndex(info.eventTypeField).String()
+	default:
+		return nil, true, fmt.Errorf("%s has no eventType field or EventType method", value.Type())
+	}
+
+	event := NewEvent(eventType)
+	for i := range info.fields {
+		f := &info.fields[i]
+		fieldValue, ok := fieldByIndex(value, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fieldValue)) {
+			continue
+		}
+
+		switch f.kind {
+		case stringField:
+			event.SetString(f.name, fieldValue.String())
+		case boolField:
+			event.SetBool(f.name, fieldValue.Bool())
+		case intField:
+			event.SetInt(f.name, fieldValue.Int())
+		case uintField:
+			if u := fieldValue.Uint(); u > math.MaxInt64 {
+				event.SetFloat(f.name, float64(u))
+			} else {
+				event.SetInt(f.name, int64(u))
+			}
+		case floatField:
+			event.SetFloat(f.name, fieldValue.Float())
+		case timeField:
+			event.SetTime(f.name, fieldValue.Interface().(time.Time))
+		case durationField:
+			event.SetFloat(f.name, time.Duration(fieldValue.Int()).Seconds())
+		}
+	}
+
+	return event, true, nil
+}
+
+// cachedStructInfo returns the encoding metadata of t, computing it once
+func cachedStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+
+	info := &structInfo{}
+	info.err = info.addFields(t, "", nil, 0)
+
+	actual, _ := structInfoCache.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+// addFields collects the attribute fields of t, prefixing their names
+func (info *structInfo) addFields(t reflect.Type, prefix string, index []int, depth int) error {
+	if depth > maxStructDepth {
+		return fmt.Errorf("%s is nested too deeply", t)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous { // unexported
+			continue
+		}
+
+		tag, hasTag := field.Tag.Lookup("insights")
+		if hasTag {
+			info.tagged = true
+		} else {
+			tag = field.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := parseTag(tag)
+		fieldIndex := append(append([]int(nil), index...), i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		// Embedded structs without a name are merged into their parent
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			if err := info.addFields(fieldType, prefix, fieldIndex, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		name = prefix + name
+
+		if name == "eventType" {
+			if fieldType.Kind() != reflect.String {
+				return fmt.Errorf("eventType field of %s must be a string", t)
+			}
+			info.eventTypeField = fieldIndex
+			continue
+		}
+
+		kind, ok := kindOf(fieldType)
+		if !ok {
+			if fieldType.Kind() == reflect.Struct {
+				if err := info.addFields(fieldType, name+".", fieldIndex, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("field %s of %s has unsupported type %s", field.Name, t, field.Type)
+		}
+
+		info.fields = append(info.fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			kind:      kind,
+			omitEmpty: hasTagOption(opts, "omitempty"),
+		})
+	}
+
+	return nil
+}
+
+// kindOf maps a Go type to the way it is encoded
+func kindOf(t reflect.Type) (fieldKind, bool) {
+	switch t {
+	case timeType:
+		return timeField, true
+	case durationType:
+		return durationField, true
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return stringField, true
+	case reflect.Bool:
+		return boolField, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intField, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return uintField, true
+	case reflect.Float32, reflect.Float64:
+		return floatField, true
+	}
+	return 0, false
+}
+
+// parseTag splits an insights tag into its name and options
+func parseTag(tag string) (string, string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// hasTagOption reports whether the comma separated tag options include option
+func hasTagOption(opts string, option string) bool {
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, but reports false instead of
+// panicking on nil pointers along the way.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is the zero value for omitempty
+func isEmptyValue(v reflect.Value) bool {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).IsZero()
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// +build unit
+
+package client
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRequest struct {
+	Method string `insights:"method"`
+	Status int    `insights:"status,omitempty"`
+}
+
+type testTaggedEvent struct {
+	EventType string        `insights:"eventType"`
+	Name      string        `insights:"name"`
+	Count     uint16        `insights:"count"`
+	Ratio     float32       `insights:"ratio,omitempty"`
+	OK        bool          `insights:"ok"`
+	At        time.Time     `insights:"at,omitempty"`
+	Took      time.Duration `insights:"took"`
+	Request   testRequest   `insights:"request"`
+	Parent    *testRequest  `insights:"parent"`
+	Secret    string        `insights:"-"`
+	Untagged  string
+	private   string
+}
+
+type testTypedEvent struct {
+	Host    string `json:"host"`
+	Port    int    `json:"port,omitempty"`
+	Secret  string `json:"-"`
+	Version string
+}
+
+func (testTypedEvent) EventType() string { return "Typed" }
+
+func decodeEvent(t *testing.T, data []byte) map[string]interface{} {
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &event))
+	return event
+}
+
+func TestMarshalStructTags(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+
+	at := time.Unix(1500000000, 0)
+	data, err := client.marshalEvent(&testTaggedEvent{
+		EventType: "Tagged",
+		Name:      "widget",
+		Count:     3,
+		OK:        true,
+		At:        at,
+		Took:      1500 * time.Millisecond,
+		Request:   testRequest{Method: "GET"},
+		Secret:    "hidden",
+		Untagged:  "kept",
+		private:   "hidden",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"eventType":      "Tagged",
+		"name":           "widget",
+		"count":          float64(3),
+		"ok":             true,
+		"at":             float64(1500000000000),
+		"took":           1.5,
+		"request.method": "GET",
+		"Untagged":       "kept",
+	}, decodeEvent(t, data))
+}
+
+func TestMarshalEventTypeMethod(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+
+	data, err := client.marshalEvent(testTypedEvent{Host: "web-1", Secret: "hidden", Version: "1.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"eventType": "Typed",
+		"host":      "web-1",
+		"Version":   "1.2",
+	}, decodeEvent(t, data), "Fields without insights tags keep their json names")
+}
+
+func TestMarshalStructErrors(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+
+	_, err := client.marshalEvent(struct {
+		Name string `insights:"name"`
+	}{Name: "x"})
+	assert.Error(t, err, "Tagged structs need an eventType")
+
+	_, err = client.marshalEvent(struct {
+		EventType string   `insights:"eventType"`
+		Tags      []string `insights:"tags"`
+	}{EventType: "test"})
+	assert.Error(t, err, "Slices are not supported attribute values")
+
+	// Structs without insights tags still go through encoding/json
+	data, err := client.marshalEvent(struct {
+		EventType string `json:"eventType"`
+		Name      string `json:"name"`
+	}{EventType: "test", Name: "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"eventType": "test", "name": "x"}, decodeEvent(t, data))
+}
+
+func TestMarshalStructOptions(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+
+	type options struct {
+		EventType string `insights:"eventType"`
+		Port      int    `insights:"port,string,omitempty"`
+		Big       uint64 `insights:"big"`
+		Small     uint64 `insights:"small"`
+	}
+
+	data, err := client.marshalEvent(options{EventType: "test", Big: math.MaxUint64, Small: 42})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"eventType": "test",
+		"big":       float64(math.MaxUint64),
+		"small":     float64(42),
+	}, decodeEvent(t, data), "omitempty is found among other options, large uints don't wrap")
+}
+
+func BenchmarkMarshalStructTags(b *testing.B) {
+	client := NewInsertClient(testKey, testID)
+	event := &testTaggedEvent{
+		EventType: "Tagged",
+		Name:      "widget",
+		Count:     3,
+		At:        time.Now(),
+		Took:      time.Second,
+		Request:   testRequest{Method: "GET", Status: 200},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.marshalEvent(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
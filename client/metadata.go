@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+)
+
+// MetadataProvider returns attributes describing where events come from.
+// The providers in InsertClient.MetadataProviders are called once, and
+// their attributes are added to every event like CommonAttributes.
+type MetadataProvider func() map[string]interface{}
+
+// cgroupPath lists the control groups of the process, which name the
+// container it runs in
+var cgroupPath = "/proc/self/cgroup"
+
+// containerIDPattern matches the 64 hex digits of a container ID in a
+// cgroup path, as used by Docker, containerd and CRI-O
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Kubernetes metadata, from the environment variables set by the New Relic
+// Kubernetes integration or, failing that, the names commonly used with
+// the downward API
+var kubernetesEnv = []struct {
+	attribute string
+	env       []string
+}{
+	{"k8sPodName", []string{"NEW_RELIC_METADATA_KUBERNETES_POD_NAME", "POD_NAME"}},
+	{"k8sNamespace", []string{"NEW_RELIC_METADATA_KUBERNETES_NAMESPACE_NAME", "POD_NAMESPACE"}},
+	{"k8sNodeName", []string{"NEW_RELIC_METADATA_KUBERNETES_NODE_NAME", "NODE_NAME"}},
+	{"k8sContainerName", []string{"NEW_RELIC_METADATA_KUBERNETES_CONTAINER_NAME", "CONTAINER_NAME"}},
+	{"k8sClusterName", []string{"NEW_RELIC_METADATA_KUBERNETES_CLUSTER_NAME", "CLUSTER_NAME"}},
+}
+
+// DefaultMetadataProviders returns every built-in MetadataProvider
+func DefaultMetadataProviders() []MetadataProvider {
+	return []MetadataProvider{
+		HostMetadata,
+		ProcessMetadata,
+		BuildMetadata,
+		ContainerMetadata,
+	}
+}
+
+// HostMetadata provides the hostname
+func HostMetadata() map[string]interface{} {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil
+	}
+	return map[string]interface{}{"hostname": hostname}
+}
+
+// ProcessMetadata provides the PID, Go version, GOOS and GOARCH
+func ProcessMetadata() map[string]interface{} {
+	return map[string]interface{}{
+		"pid":       os.Getpid(),
+		"goVersion": runtime.Version(),
+		"goOS":      runtime.GOOS,
+		"goArch":    runtime.GOARCH,
+	}
+}
+
+// BuildMetadata provides the path and version of the main module, and the
+// VCS revision it was built from when the binary records it (Go 1.18+)
+func BuildMetadata() map[string]interface{} {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+
+	attrs := map[string]interface{}{}
+	if info.Main.Path != "" {
+		attrs["module"] = info.Main.Path
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		attrs["moduleVersion"] = info.Main.Version
+	}
+	addVCSMetadata(info, attrs)
+
+	return attrs
+}
+
+// ContainerMetadata provides the container ID found in the process cgroups,
+// and Kubernetes pod metadata found in the environment
+func ContainerMetadata() map[string]interface{} {
+	attrs := map[string]interface{}{}
+
+	if id := containerID(cgroupPath); id != "" {
+		attrs["containerId"] = id
+	}
+
+	for _, k8s := range kubernetesEnv {
+		for _, env := range k8s.env {
+			if value := os.Getenv(env); value != "" {
+				attrs[k8s.attribute] = value
+				break
+			}
+		}
+	}
+
+	// The pod name is the hostname unless told otherwise
+	if _, ok := attrs["k8sPodName"]; !ok && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		if hostname, err := os.Hostname(); err == nil {
+			attrs["k8sPodName"] = hostname
+		}
+	}
+
+	return attrs
+}
+
+// containerID returns the container ID found in a cgroup file, if any
+func containerID(path string) string {
+	file, err := os.Open(path) // #nosec G304 -- not user input
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := containerIDPattern.FindString(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// metadata calls every MetadataProvider, later ones winning on conflicts
+func (c *InsertClient) metadata() map[string]interface{} {
+	if len(c.MetadataProviders) == 0 {
+		return nil
+	}
+
+	attrs := map[string]interface{}{}
+	for _, provider := range c.MetadataProviders {
+		for name, value := range provider() {
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,10 @@
+// +build !go1.18
+
+package client
+
+import (
+	"runtime/debug"
+)
+
+// addVCSMetadata does nothing, as VCS settings are only recorded since Go 1.18
+func addVCSMetadata(info *debug.BuildInfo, attrs map[string]interface{}) {}
@@ -0,0 +1,77 @@
+// +build unit
+
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testContainerID = "3f4e2a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+
+func TestContainerID(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, cgroup := range []string{
+		"12:memory:/docker/" + testContainerID + "\n",
+		"0::/kubepods/besteffort/pod1234/" + testContainerID + "\n",
+		"1:name=systemd:/user.slice\n0::/system.slice/docker-" + testContainerID + ".scope\n",
+	} {
+		path := filepath.Join(dir, "cgroup")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(cgroup), 0600))
+		assert.Equal(t, testContainerID, containerID(path), cgroup)
+	}
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte("0::/\n"), 0600))
+	assert.Equal(t, "", containerID(filepath.Join(dir, "cgroup")), "Processes outside containers have no ID")
+	assert.Equal(t, "", containerID(filepath.Join(dir, "missing")))
+}
+
+func TestContainerMetadata_kubernetes(t *testing.T) {
+	for _, env := range []string{"NEW_RELIC_METADATA_KUBERNETES_POD_NAME", "POD_NAME", "POD_NAMESPACE"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("NEW_RELIC_METADATA_KUBERNETES_POD_NAME", "checkout-5d8f7")
+	os.Setenv("POD_NAME", "ignored")
+	os.Setenv("POD_NAMESPACE", "shop")
+
+	attrs := ContainerMetadata()
+	assert.Equal(t, "checkout-5d8f7", attrs["k8sPodName"], "The New Relic variables come first")
+	assert.Equal(t, "shop", attrs["k8sNamespace"])
+}
+
+func TestInsertMetadataProviders(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.MetadataProviders = []MetadataProvider{
+		ProcessMetadata,
+		func() map[string]interface{} { return map[string]interface{}{"service": "meta", "region": "eu"} },
+	}
+	client.CommonAttributes = map[string]interface{}{"service": "common"}
+
+	data, err := client.marshalEvent(NewEvent("test"))
+	assert.NoError(t, err)
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, runtime.Version(), event["goVersion"])
+	assert.Equal(t, runtime.GOOS, event["goOS"])
+	assert.Equal(t, float64(os.Getpid()), event["pid"])
+	assert.Equal(t, "common", event["service"], "CommonAttributes win over metadata")
+	assert.Equal(t, "eu", event["region"])
+}
+
+func TestDefaultMetadataProviders(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.MetadataProviders = DefaultMetadataProviders()
+
+	attrs, err := client.commonAttributes()
+	assert.NoError(t, err, "Built-in metadata should always be valid attributes")
+	assert.NotEmpty(t, attrs)
+}
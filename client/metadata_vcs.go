@@ -0,0 +1,21 @@
+// +build go1.18
+
+package client
+
+import (
+	"runtime/debug"
+)
+
+// addVCSMetadata adds the VCS settings recorded in the binary
+func addVCSMetadata(info *debug.BuildInfo, attrs map[string]interface{}) {
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			attrs["vcsRevision"] = setting.Value
+		case "vcs.time":
+			attrs["vcsTime"] = setting.Value
+		case "vcs.modified":
+			attrs["vcsModified"] = setting.Value == "true"
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix is prepended to the name of every exported metric
+const metricsPrefix = "go_insights_"
+
+// sendDurationBuckets are the upper bounds, in seconds, of the send latency
+// histogram buckets
+var sendDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// sendMetrics records the latency and outcome of every request sent to
+// Insights. The zero value is ready to use.
+type sendMetrics struct {
+	mu       sync.Mutex
+	buckets  []int64 // one per sendDurationBuckets, not cumulative
+	count    int64
+	sum      float64
+	failures map[string]int64 // by failureLabel
+}
+
+// observe records a request that took d and failed with err, if not nil
+func (m *sendMetrics) observe(d time.Duration, err error) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buckets == nil {
+		m.buckets = make([]int64, len(sendDurationBuckets))
+	}
+	if i := sort.SearchFloat64s(sendDurationBuckets, seconds); i < len(m.buckets) {
+		m.buckets[i]++
+	}
+	m.count++
+	m.sum += seconds
+
+	if err != nil {
+		if m.failures == nil {
+			m.failures = make(map[string]int64)
+		}
+		m.failures[failureLabel(err)]++
+	}
+}
+
+// reset forgets every request recorded so far
+func (m *sendMetrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buckets, m.count, m.sum, m.failures = nil, 0, 0, nil
+}
+
+// sendHistogram is a copy of the send latency histogram, with cumulative
+// bucket counts as exposed by Prometheus
+type sendHistogram struct {
+	Buckets map[string]int64 `json:"buckets"`
+	Count   int64            `json:"count"`
+	Sum     float64          `json:"sum"`
+}
+
+// snapshot copies the histogram and failure counts
+func (m *sendMetrics) snapshot() (sendHistogram, map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hist := sendHistogram{
+		Buckets: make(map[string]int64, len(sendDurationBuckets)+1),
+		Count:   m.count,
+		Sum:     m.sum,
+	}
+	var cumulative int64
+	for i, le := range sendDurationBuckets {
+		if m.buckets != nil {
+			cumulative += m.buckets[i]
+		}
+		hist.Buckets[formatFloat(le)] = cumulative
+	}
+	hist.Buckets["+Inf"] = m.count
+
+	failures := make(map[string]int64, len(m.failures))
+	for label, n := range m.failures {
+		failures[label] = n
+	}
+	return hist, failures
+}
+
+// metric is a single counter or gauge exported by the client
+type metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge"
+	value float64
+}
+
+// metrics reads the current value of every counter and gauge
+func (c *InsertClient) metrics() []metric {
+	s := &c.Statistics
+	load := func(addr *int64) float64 { return float64(atomic.LoadInt64(addr)) }
+
+	return []metric{
+		{"events_enqueued_total", "Events added with EnqueueEvent.", "counter", load(&s.EventCount)},
+		{"events_processed_total", "Events that finished processing, successfully or not.", "counter", load(&s.ProcessedEventCount)},
+		{"events_sampled_out_total", "Events discarded by sampling.", "counter", load(&s.SampledOutEventCount)},
+		{"events_dropped_total", "Events dropped because the queue was full.", "counter", load(&s.DroppedEventCount)},
+		{"events_spilled_total", "Events written to the spool because the queue was full.", "counter", load(&s.SpilledEventCount)},
+		{"sent_bytes_total", "Uncompressed bytes of events sent to Insights.", "counter", load(&s.ByteCount)},
+		{"flushes_total", "Flushes requested by the caller.", "counter", load(&s.FlushCount)},
+		{"full_flushes_total", "Batches sent because they were full.", "counter", load(&s.FullFlushCount)},
+		{"partial_flushes_total", "Batches sent before they were full.", "counter", load(&s.PartialFlushCount)},
+		{"timer_flushes_total", "Flushes caused by BatchTime expiring.", "counter", load(&s.TimerExpiredCount)},
+		{"retries_total", "Batches sent again after a failed attempt.", "counter", load(&s.InsightsRetryCount)},
+		{"split_batches_total", "Batches split after being rejected as too large.", "counter", load(&s.SplitBatchCount)},
+		{"spooled_batches_total", "Failed batches written to the spool.", "counter", load(&s.SpooledBatchCount)},
+		{"replayed_batches_total", "Spooled batches that were resent.", "counter", load(&s.ReplayedBatchCount)},
+		{"spool_evicted_batches_total", "Spooled batches discarded to stay within SpoolMaxBytes.", "counter", load(&s.SpoolEvictedCount)},
+		{"rate_limited_requests_total", "Requests delayed by the rate limits.", "counter", load(&s.RateLimitedCount)},
+		{"rate_limit_wait_seconds_total", "Time requests waited for the rate limits.", "counter", load(&s.RateLimitWaitTime) / float64(time.Second)},
+		{"circuit_opened_total", "Times the circuit breaker opened.", "counter", load(&s.CircuitOpenCount)},
+		{"circuit_rejected_requests_total", "Requests failed because the circuit breaker was open.", "counter", load(&s.CircuitRejectedCount)},
+		{"circuit_state", "State of the circuit breaker: 0 closed, 1 open, 2 half-open.", "gauge", load(&s.CircuitStateValue)},
+		{"queued_events", "Events waiting in the queue.", "gauge", float64(c.queueDepth())},
+		{"waiting_batches", "Batches waiting for a free worker.", "gauge", load(&s.WaitingBatchCount)},
+		{"in_flight_batches", "Batches being sent, including retries.", "gauge", load(&s.InFlightBatchCount)},
+		{"buffered_bytes", "Bytes of events queued, batched or being sent.", "gauge", load(&s.BufferedBytes)},
+	}
+}
+
+// queueDepth returns the number of events waiting in the queue
+func (c *InsertClient) queueDepth() int {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return len(c.eventQueue)
+}
+
+// MetricsHandler returns an http.Handler that renders the client Statistics,
+// the queue depth, a histogram of send latencies and the failed sends by
+// cause, as reported by HTTPErrors, in the Prometheus text exposition format.
+func (c *InsertClient) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := c.writeMetrics(w); err != nil {
+			c.Logger.Errorf("failed to write metrics: %v", err)
+		}
+	})
+}
+
+// writeMetrics writes every metric in the Prometheus text exposition format
+func (c *InsertClient) writeMetrics(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	header := func(name, help, kind string) {
+		printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+	}
+
+	for _, m := range c.metrics() {
+		header(m.name, m.help, m.kind)
+		printf("%s%s %s\n", metricsPrefix, m.name, formatFloat(m.value))
+	}
+
+	hist, failures := c.sendMetrics.snapshot()
+
+	header("send_duration_seconds", "Duration of requests sent to Insights.", "histogram")
+	for _, le := range sendDurationBuckets {
+		printf("%ssend_duration_seconds_bucket{le=\"%s\"} %d\n", metricsPrefix, formatFloat(le), hist.Buckets[formatFloat(le)])
+	}
+	printf("%ssend_duration_seconds_bucket{le=\"+Inf\"} %d\n", metricsPrefix, hist.Count)
+	printf("%ssend_duration_seconds_sum %s\n", metricsPrefix, formatFloat(hist.Sum))
+	printf("%ssend_duration_seconds_count %d\n", metricsPrefix, hist.Count)
+
+	header("send_failures_total", "Requests to Insights that failed, by HTTP status or network error class.", "counter")
+	labels := make([]string, 0, len(failures))
+	for label := range failures {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		printf("%ssend_failures_total{cause=%q} %d\n", metricsPrefix, label, failures[label])
+	}
+
+	return err
+}
+
+// PublishExpvar publishes the metrics of the client as a JSON object under
+// name in the expvar package, and so on /debug/vars. It fails if the name
+// is already in use.
+func (c *InsertClient) PublishExpvar(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		vars := make(map[string]interface{})
+		for _, m := range c.metrics() {
+			vars[m.name] = m.value
+		}
+		vars["send_duration_seconds"], vars["send_failures_total"] = c.sendMetrics.snapshot()
+		return vars
+	}))
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,77 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMetricsObserve(t *testing.T) {
+	var m sendMetrics
+	m.observe(3*time.Millisecond, nil)
+	m.observe(200*time.Millisecond, &APIError{StatusCode: http.StatusServiceUnavailable})
+	m.observe(time.Minute, &APIError{StatusCode: http.StatusServiceUnavailable})
+	m.observe(time.Second, errors.New("connection refused"))
+
+	hist, failures := m.snapshot()
+	assert.Equal(t, int64(4), hist.Count)
+	assert.InDelta(t, 61.203, hist.Sum, 0.0001)
+	assert.Equal(t, int64(1), hist.Buckets["0.005"])
+	assert.Equal(t, int64(2), hist.Buckets["0.25"])
+	assert.Equal(t, int64(3), hist.Buckets["1"], "Buckets are inclusive of their upper bound")
+	assert.Equal(t, int64(3), hist.Buckets["30"])
+	assert.Equal(t, int64(4), hist.Buckets["+Inf"])
+	assert.Equal(t, map[string]int64{"503": 2, "other": 1}, failures)
+}
+
+func TestInsertMetricsHandler(t *testing.T) {
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	assert.Error(t, client.sendEvents(context.Background(), testInsertJSON))
+	ts.Config.Handler = testInsertHandlerSuccess
+	assert.NoError(t, client.sendEvents(context.Background(), testInsertJSON))
+	client.Statistics.EventCount = 7
+
+	rec := httptest.NewRecorder()
+	client.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"))
+	assert.Contains(t, body, "# TYPE go_insights_events_enqueued_total counter\ngo_insights_events_enqueued_total 7\n")
+	assert.Contains(t, body, "# TYPE go_insights_queued_events gauge\ngo_insights_queued_events 0\n")
+	assert.Contains(t, body, "# TYPE go_insights_send_duration_seconds histogram\n")
+	assert.Contains(t, body, "go_insights_send_duration_seconds_bucket{le=\"+Inf\"} 2\n")
+	assert.Contains(t, body, "go_insights_send_duration_seconds_count 2\n")
+	assert.Contains(t, body, "go_insights_send_failures_total{cause=\"503\"} 1\n")
+}
+
+func TestInsertPublishExpvar(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.Statistics.DroppedEventCount = 3
+
+	// expvar names are global to the process
+	name := fmt.Sprintf("insightsTestClient%d", time.Now().UnixNano())
+	assert.NoError(t, client.PublishExpvar(name))
+	assert.Error(t, client.PublishExpvar(name), "Names can only be published once")
+
+	var vars map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &vars))
+	assert.Equal(t, float64(3), vars["events_dropped_total"])
+	assert.Contains(t, vars, "send_duration_seconds")
+	assert.Contains(t, vars, "send_failures_total")
+}
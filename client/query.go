@@ -1,13 +1,14 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"regexp"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -16,6 +17,7 @@ import (
 func NewQueryClient(queryKey, accountID string) *QueryClient {
 	client := &QueryClient{}
 	client.URL = createQueryURL(accountID)
+	client.accountID = accountID
 	client.QueryKey = queryKey
 	client.Logger = log.New()
 
@@ -28,15 +30,13 @@ func NewQueryClient(queryKey, accountID string) *QueryClient {
 }
 
 func createQueryURL(accountID string) *url.URL {
-	insightsURL, _ := url.Parse(insightsQueryURL)
-	insightsURL.Path = fmt.Sprintf("%s/%s/query", insightsURL.Path, accountID)
-	return insightsURL
+	return endpointURL(insightsQueryURL, accountID, "query")
 }
 
 // Validate makes sure the QueryClient is configured correctly for use
 func (c *QueryClient) Validate() error {
-	if correct, _ := regexp.MatchString("api.newrelic.com/v1/accounts/[0-9]+/query", c.URL.String()); !correct {
-		return fmt.Errorf("invalid query endpoint %s", c.URL)
+	if err := validateEndpoint(c.URL, "query"); err != nil {
+		return err
 	}
 
 	if len(c.QueryKey) < 1 {
@@ -47,8 +47,13 @@ func (c *QueryClient) Validate() error {
 
 // QueryEvents initiates an Insights query, returns a response for parsing
 func (c *QueryClient) QueryEvents(nrqlQuery string) (response *QueryResponse, err error) {
+	return c.QueryEventsContext(context.Background(), nrqlQuery)
+}
+
+// QueryEventsContext is QueryEvents, giving up when ctx is done
+func (c *QueryClient) QueryEventsContext(ctx context.Context, nrqlQuery string) (response *QueryResponse, err error) {
 	response = &QueryResponse{}
-	err = c.Query(nrqlQuery, response)
+	err = c.QueryContext(ctx, nrqlQuery, response)
 	if err != nil {
 		return nil, err
 	}
@@ -58,20 +63,27 @@ func (c *QueryClient) QueryEvents(nrqlQuery string) (response *QueryResponse, er
 
 // Query initiates an Insights query, with the JSON parsed into 'response' struct
 func (c *QueryClient) Query(nrqlQuery string, response interface{}) (err error) {
+	return c.QueryContext(context.Background(), nrqlQuery, response)
+}
+
+// QueryContext is Query, giving up when ctx is done, including while
+// waiting to retry
+func (c *QueryClient) QueryContext(ctx context.Context, nrqlQuery string, response interface{}) (err error) {
 	if response == nil {
 		return errors.New("go-insights: Invalid query response can not be nil")
 	}
 
-	err = c.queryRequest(nrqlQuery, response)
-	if err != nil {
-		return err
-	}
-	return nil
+	_, err = c.retry(ctx, func() error {
+		return c.queryRequest(ctx, nrqlQuery, response)
+	}, func(attempt int, wait time.Duration, err error) {
+		c.Logger.Warnf("Insights query failed [attempt %d]. Will retry in %s. Error: %v", attempt, wait, err)
+	})
+	return err
 }
 
 // queryRequest makes a NRQL query and returns the result in `queryResult`
 // which must be a pointer to a struct that the JSON package can unmarshall
-func (c *QueryClient) queryRequest(nrqlQuery string, queryResult interface{}) (err error) {
+func (c *QueryClient) queryRequest(ctx context.Context, nrqlQuery string, queryResult interface{}) (err error) {
 	var request *http.Request
 	var response *http.Response
 
@@ -92,11 +104,12 @@ func (c *QueryClient) queryRequest(nrqlQuery string, queryResult interface{}) (e
 	request.Header.Add("Accept", "application/json")
 	request.Header.Add("X-Query-Key", c.QueryKey)
 
-	client := &http.Client{Timeout: c.RequestTimeout}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 
-	response, err = client.Do(request)
+	response, err = c.httpClient().Do(request.WithContext(ctx))
 	if err != nil {
-		err = fmt.Errorf("failed query request for: %v", err)
+		err = fmt.Errorf("failed query request for: %w", err)
 		return
 	}
 	defer func() {
@@ -106,14 +119,9 @@ func (c *QueryClient) queryRequest(nrqlQuery string, queryResult interface{}) (e
 		}
 	}()
 
-	if response.StatusCode != http.StatusOK {
-		err = fmt.Errorf("bad response code: %d", response.StatusCode)
-		return
-	}
-
 	err = c.parseResponse(response, queryResult)
 	if err != nil {
-		err = fmt.Errorf("failed query: %v", err)
+		err = fmt.Errorf("failed query: %w", err)
 	}
 
 	return err
@@ -143,13 +151,17 @@ func (c *QueryClient) generateQueryURL(nrqlQuery string) (string, error) {
 func (c *QueryClient) parseResponse(response *http.Response, parsedResponse interface{}) error {
 	body, readErr := ioutil.ReadAll(response.Body)
 	if readErr != nil {
-		return fmt.Errorf("failed to read response body: %s", readErr.Error())
+		return fmt.Errorf("failed to read response body: %w", readErr)
 	}
 
 	c.Logger.Debugf("Response %d body: %s", response.StatusCode, body)
 
+	if response.StatusCode != http.StatusOK {
+		return newAPIError(response, body)
+	}
+
 	if jsonErr := json.Unmarshal(body, parsedResponse); jsonErr != nil {
-		return fmt.Errorf("unable to unmarshal query response: %v", jsonErr)
+		return fmt.Errorf("unable to unmarshal query response: %w", jsonErr)
 	}
 
 	return nil
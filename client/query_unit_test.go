@@ -3,8 +3,13 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -103,7 +108,7 @@ func TestQueryClientQueryRequest(t *testing.T) {
 	// Empty NRQL
 	res = &QueryResponse{}
 	query := ""
-	err = client.queryRequest(query, res)
+	err = client.queryRequest(context.Background(), query, res)
 	assert.Error(t, err, "Empty NRQL query should fail")
 }
 
@@ -122,7 +127,7 @@ func TestQueryClientQueryRequest_decodeFailure(t *testing.T) {
 	// NIL result pointer
 	query, err := client.generateQueryURL(testNRQLQuery)
 	assert.NoError(t, err)
-	err = client.queryRequest(query, nil)
+	err = client.queryRequest(context.Background(), query, nil)
 	assert.Error(t, err, "Empty result pointer should fail")
 }
 
@@ -138,6 +143,7 @@ func TestQueryClientQueryEvents_bad(t *testing.T) {
 	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
 	assert.NoError(t, err)
 	assert.Equal(t, ts.URL, client.URL.String())
+	client.RetryWait = time.Millisecond // Server errors are retried
 
 	// Invalid requests
 	resp, err = client.QueryEvents("")
@@ -174,3 +180,60 @@ func TestQueryClientQueryEvents_good(t *testing.T) {
 	assert.NoError(t, err, "Valid query to test server should not return error")
 	assert.NotNil(t, resp, "Response should not be nil")
 }
+
+func TestQueryClientQuery_retry(t *testing.T) {
+	var err error
+	var attempts int32
+
+	// Fail once, then succeed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		testQueryHandlerEmpty.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewQueryClient(testKey, testID)  // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryPolicy = &ExponentialBackoff{InitialInterval: time.Millisecond}
+
+	_, err = client.QueryEvents(testNRQLQuery)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+
+	// Client errors are not retried
+	atomic.StoreInt32(&attempts, 0)
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	_, err = client.QueryEvents(testNRQLQuery)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestQueryClientQueryContext_cancel(t *testing.T) {
+	var err error
+	var attempts int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewQueryClient(testKey, testID)  // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryWait = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.QueryEventsContext(ctx, testNRQLQuery)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "The retry wait should be cancelled: %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket allows rate tokens per second, with bursts of up to burst
+// tokens. Callers may take more tokens than are available, leaving the
+// bucket in debt, and wait until the debt is paid off; this lets requests
+// larger than the burst through while keeping the average rate.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// reserve takes n tokens and returns how long to wait before using them
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns n reserved tokens that were not used
+func (b *tokenBucket) cancel(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// rateLimiter holds the token buckets enforcing the rate limits of an
+// InsertClient
+type rateLimiter struct {
+	requests     *tokenBucket
+	events       *tokenBucket
+	eventsByType map[string]*tokenBucket
+}
+
+// newRateLimiter builds the limiter for the configured limits, or returns
+// nil if there are none
+func newRateLimiter(requestsPerSecond float64, eventsPerMinute int, eventsPerMinuteByType map[string]int) *rateLimiter {
+	limiter := &rateLimiter{}
+	limited := false
+
+	if requestsPerSecond > 0 {
+		limiter.requests = newTokenBucket(requestsPerSecond, math.Max(1, requestsPerSecond))
+		limited = true
+	}
+
+	perMinute := func(n int) *tokenBucket {
+		return newTokenBucket(float64(n)/time.Minute.Seconds(), float64(n))
+	}
+	if eventsPerMinute > 0 {
+		limiter.events = perMinute(eventsPerMinute)
+		limited = true
+	}
+	for eventType, n := range eventsPerMinuteByType {
+		if n <= 0 {
+			continue
+		}
+		if limiter.eventsByType == nil {
+			limiter.eventsByType = make(map[string]*tokenBucket)
+		}
+		limiter.eventsByType[eventType] = perMinute(n)
+		limited = true
+	}
+
+	if !limited {
+		return nil
+	}
+	return limiter
+}
+
+// wait blocks until a request sending events is allowed by every limit, or
+// ctx is done. It returns how long it waited.
+func (l *rateLimiter) wait(ctx context.Context, events [][]byte) (time.Duration, error) {
+	type reservation struct {
+		bucket *tokenBucket
+		n      float64
+	}
+	var reservations []reservation
+	var delay time.Duration
+	now := time.Now()
+
+	reserve := func(bucket *tokenBucket, n float64) {
+		if bucket == nil || n == 0 {
+			return
+		}
+		reservations = append(reservations, reservation{bucket, n})
+		if d := bucket.reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+
+	reserve(l.requests, 1)
+	reserve(l.events, float64(len(events)))
+	if l.eventsByType != nil {
+		counts := make(map[string]int)
+		for _, event := range events {
+			if eventType, ok := eventTypeOf(nil, event); ok {
+				if _, limited := l.eventsByType[eventType]; limited {
+					counts[eventType]++
+				}
+			}
+		}
+		for eventType, n := range counts {
+			reserve(l.eventsByType[eventType], float64(n))
+		}
+	}
+
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		for _, r := range reservations {
+			r.bucket.cancel(r.n)
+		}
+		return time.Since(now), ctx.Err()
+	}
+}
+
+// rateLimiter returns the limiter built from the configured limits, or nil
+func (c *InsertClient) rateLimiter() *rateLimiter {
+	c.limiterOnce.Do(func() {
+		c.limiter = newRateLimiter(c.MaxRequestsPerSecond, c.MaxEventsPerMinute, c.MaxEventsPerMinuteByType)
+	})
+	return c.limiter
+}
+
+// waitForRateLimit blocks until sending events is allowed by the rate
+// limits, or ctx is done, recording the time spent waiting.
+func (c *InsertClient) waitForRateLimit(ctx context.Context, events [][]byte) error {
+	limiter := c.rateLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	waited, err := limiter.wait(ctx, events)
+	if waited > 0 {
+		atomic.AddInt64(&c.Statistics.RateLimitedCount, 1)
+		atomic.AddInt64(&c.Statistics.RateLimitWaitTime, int64(waited))
+	}
+	return err
+}
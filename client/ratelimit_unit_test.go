@@ -0,0 +1,111 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(10, 5)
+
+	assert.Equal(t, time.Duration(0), bucket.reserve(5, now), "The burst is available right away")
+	assert.Equal(t, 100*time.Millisecond, bucket.reserve(1, now))
+	assert.Equal(t, 150*time.Millisecond, bucket.reserve(1, now.Add(50*time.Millisecond)), "Debt is paid at the rate")
+	assert.Equal(t, time.Duration(0), bucket.reserve(5, now.Add(time.Hour)), "Tokens never exceed the burst")
+	assert.Equal(t, 2*time.Second, bucket.reserve(20, now.Add(time.Hour)), "Requests may be larger than the burst")
+
+	bucket.cancel(20)
+	assert.Equal(t, 100*time.Millisecond, bucket.reserve(1, now.Add(time.Hour)), "Cancelled tokens are returned")
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 0, nil))
+	assert.Nil(t, newRateLimiter(0, 0, map[string]int{"test": 0}))
+
+	limiter := newRateLimiter(0.5, 120, map[string]int{"test": 60})
+	assert.Equal(t, float64(1), limiter.requests.burst, "At least one request is allowed at a time")
+	assert.Equal(t, float64(2), limiter.events.rate)
+	assert.Equal(t, float64(1), limiter.eventsByType["test"].rate)
+}
+
+func TestRateLimiterWait_byType(t *testing.T) {
+	limiter := newRateLimiter(0, 0, map[string]int{"test": 6000})
+	other := [][]byte{[]byte(`{"eventType":"other"}`)}
+	limited := [][]byte{[]byte(`{"eventType":"test"}`), []byte(`{"eventType":"test"}`)}
+
+	// 100 events a second, 6000 at once
+	waited, err := limiter.wait(context.Background(), make([][]byte, 10))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), waited, "Events without an eventType are not limited by type")
+
+	limiter.eventsByType["test"].reserve(6000, time.Now())
+	waited, err = limiter.wait(context.Background(), other)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), waited, "Other types are not limited")
+
+	waited, err = limiter.wait(context.Background(), limited)
+	assert.NoError(t, err)
+	assert.True(t, waited > 0 && waited <= 20*time.Millisecond, "Waited %s", waited)
+}
+
+func TestRateLimiterWait_cancel(t *testing.T) {
+	limiter := newRateLimiter(1, 0, nil)
+	limiter.requests.reserve(1, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := limiter.wait(ctx, nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, limiter.requests.tokens > -1, "Cancelled reservations are returned")
+}
+
+func TestInsertRateLimit(t *testing.T) {
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.MaxRequestsPerSecond = 20
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		assert.NoError(t, client.sendEvents(context.Background(), testInsertJSON))
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond, "Requests over the burst should wait")
+
+	stats := client.Snapshot()
+	assert.Equal(t, int64(2), stats.RateLimitedCount)
+	assert.True(t, stats.RateLimitWaitTime > 0)
+
+	assert.NoError(t, client.PostEvent(testInsertJSONString))
+	assert.Equal(t, int64(3), client.Snapshot().RateLimitedCount, "PostEvent is rate limited too")
+}
+
+func TestInsertRateLimit_postedArray(t *testing.T) {
+	ts := httptest.NewServer(testInsertHandlerSuccess)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.MaxEventsPerMinuteByType = map[string]int{"test": 60}
+	client.rateLimiter().eventsByType["test"].reserve(60, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.PostEventContext(ctx, `[{"eventType":"other"},{"eventType":"test"}]`)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "Every event of an array is limited by type: %v", err)
+
+	client = NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.MaxEventsPerMinute = 60
+	assert.NoError(t, client.PostEvent(`[{"eventType":"test"},{"eventType":"test"},{"eventType":"test"}]`))
+	assert.InDelta(t, 57, client.rateLimiter().events.tokens, 0.5, "Every event of an array is counted")
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Region is the New Relic data center an account's data lives in
+type Region string
+
+// Supported regions
+const (
+	RegionUS      Region = "US"
+	RegionEU      Region = "EU"
+	RegionFedRAMP Region = "FedRAMP"
+)
+
+// regionEndpoint holds the base URLs of a region
+type regionEndpoint struct {
+	insert string
+	query  string
+}
+
+var regionEndpoints = map[Region]regionEndpoint{
+	RegionUS:      {insert: insightsInsertURL, query: insightsQueryURL},
+	RegionEU:      {insert: insightsInsertURLEU, query: insightsQueryURLEU},
+	RegionFedRAMP: {insert: insightsInsertURLFedRAMP, query: insightsQueryURLFedRAMP},
+}
+
+// endpointPathPattern matches the path of an insert or query endpoint. Any
+// prefix is allowed, for endpoints behind a proxy.
+var endpointPathPattern = regexp.MustCompile(`/v1/accounts/[0-9]+/(events|query)$`)
+
+// ParseRegion returns the Region named by s, ignoring case
+func ParseRegion(s string) (Region, error) {
+	for region := range regionEndpoints {
+		if strings.EqualFold(s, string(region)) {
+			return region, nil
+		}
+	}
+	return "", fmt.Errorf("unknown region: %q", s)
+}
+
+// SetRegion points the client at the insert endpoint of region
+func (c *InsertClient) SetRegion(region Region) error {
+	endpoint, ok := regionEndpoints[region]
+	if !ok {
+		return fmt.Errorf("unknown region: %q", region)
+	}
+
+	c.URL = endpointURL(endpoint.insert, c.accountID, "events")
+	c.Logger.Debugf("Using region %s: %s", region, c.URL)
+	return nil
+}
+
+// SetRegion points the client at the query endpoint of region
+func (c *QueryClient) SetRegion(region Region) error {
+	endpoint, ok := regionEndpoints[region]
+	if !ok {
+		return fmt.Errorf("unknown region: %q", region)
+	}
+
+	c.URL = endpointURL(endpoint.query, c.accountID, "query")
+	c.Logger.Debugf("Using region %s: %s", region, c.URL)
+	return nil
+}
+
+// endpointURL builds the URL of an account's endpoint from a base URL
+func endpointURL(base, accountID, endpoint string) *url.URL {
+	endpointURL, _ := url.Parse(base)
+	endpointURL.Path = fmt.Sprintf("%s/%s/%s", endpointURL.Path, accountID, endpoint)
+	return endpointURL
+}
+
+// validateEndpoint checks the structure of an endpoint URL: an http or https
+// scheme, a host, and a path ending in /v1/accounts/<account ID>/<endpoint>.
+func validateEndpoint(endpointURL *url.URL, endpoint string) error {
+	if endpointURL == nil {
+		return fmt.Errorf("no %s endpoint set", endpoint)
+	}
+
+	switch {
+	case endpointURL.Scheme != "http" && endpointURL.Scheme != "https":
+		return fmt.Errorf("invalid %s endpoint %s: scheme must be http or https", endpoint, endpointURL)
+	case endpointURL.Hostname() == "":
+		return fmt.Errorf("invalid %s endpoint %s: missing host", endpoint, endpointURL)
+	}
+
+	matches := endpointPathPattern.FindStringSubmatch(endpointURL.Path)
+	if matches == nil || matches[1] != endpoint {
+		return fmt.Errorf("invalid %s endpoint %s: path must end in /v1/accounts/<account ID>/%s", endpoint, endpointURL, endpoint)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+// +build unit
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegion(t *testing.T) {
+	region, err := ParseRegion("eu")
+	assert.NoError(t, err)
+	assert.Equal(t, RegionEU, region)
+
+	_, err = ParseRegion("mars")
+	assert.Error(t, err)
+}
+
+func TestSetRegion(t *testing.T) {
+	insert := NewInsertClient(testKey, testID)
+	assert.NoError(t, insert.SetRegion(RegionEU))
+	assert.Equal(t, insightsInsertURLEU+"/"+testID+"/events", insert.URL.String())
+	assert.NoError(t, insert.Validate())
+
+	query := NewQueryClient(testKey, testID)
+	assert.NoError(t, query.SetRegion(RegionFedRAMP))
+	assert.Equal(t, insightsQueryURLFedRAMP+"/"+testID+"/query", query.URL.String())
+	assert.NoError(t, query.Validate())
+
+	assert.Error(t, query.SetRegion(Region("mars")))
+	assert.Equal(t, insightsQueryURLFedRAMP+"/"+testID+"/query", query.URL.String(), "URL should not change on error")
+}
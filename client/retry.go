@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBackoffInitialInterval is the upper bound of the first wait of an ExponentialBackoff
+	DefaultBackoffInitialInterval = 1 * time.Second
+	// DefaultBackoffMaxInterval is the largest upper bound for a single wait of an ExponentialBackoff
+	DefaultBackoffMaxInterval = 30 * time.Second
+	// DefaultBackoffMultiplier is how much the upper bound of the wait grows after every attempt
+	DefaultBackoffMultiplier = 2.0
+)
+
+// RetryPolicy decides whether a failed request is attempted again, and how
+// long to wait before doing so.
+type RetryPolicy interface {
+	// Backoff is called after a failed attempt with the number of attempts
+	// made so far, the time elapsed since the first one started, and the
+	// error returned by the last one. It returns how long to wait before
+	// the next attempt, or false if the request should not be retried.
+	Backoff(attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+}
+
+// ConstantBackoff retries every retryable error after the same wait. This
+// is the policy used when Client.RetryPolicy is not set, built from
+// Client.RetryCount and Client.RetryWait.
+type ConstantBackoff struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+	// Wait is the time between attempts
+	Wait time.Duration
+}
+
+// Backoff implements RetryPolicy
+func (p *ConstantBackoff) Backoff(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !retryable(err) {
+		return 0, false
+	}
+
+	if wait := retryAfter(err); wait > p.Wait {
+		return wait, true
+	}
+	return p.Wait, true
+}
+
+// ExponentialBackoff retries retryable errors with exponentially growing,
+// fully jittered waits: the wait before attempt n+1 is chosen at random
+// between zero and min(MaxInterval, InitialInterval * Multiplier^(n-1)).
+// A Retry-After header sent with a 429 or 503 response is honored instead
+// when it asks for a longer wait.
+//
+// Zero values use the matching Default* constant; MaxElapsedTime of zero
+// means no limit.
+type ExponentialBackoff struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// MaxElapsedTime stops retrying once the next attempt would start
+	// this long after the first one
+	MaxElapsedTime time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Backoff implements RetryPolicy
+func (p *ExponentialBackoff) Backoff(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetries
+	}
+	if attempt >= maxAttempts || !retryable(err) {
+		return 0, false
+	}
+
+	initial, maxInterval, multiplier := p.InitialInterval, p.MaxInterval, p.Multiplier
+	if initial <= 0 {
+		initial = DefaultBackoffInitialInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = DefaultBackoffMaxInterval
+	}
+	if multiplier < 1 {
+		multiplier = DefaultBackoffMultiplier
+	}
+
+	ceiling := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if ceiling > float64(maxInterval) {
+		ceiling = float64(maxInterval)
+	}
+	wait := p.jitter(time.Duration(ceiling))
+
+	if after := retryAfter(err); after > wait {
+		wait = after
+	}
+
+	if p.MaxElapsedTime > 0 && elapsed+wait > p.MaxElapsedTime {
+		return 0, false
+	}
+
+	return wait, true
+}
+
+// jitter returns a random duration in [0, d]
+func (p *ExponentialBackoff) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(time.Now().UnixNano())) // #nosec G404 -- jitter does not need a secure source
+	}
+	return time.Duration(p.rand.Int63n(int64(d) + 1))
+}
+
+// retryable reports whether an attempt that failed with err might succeed
+// if tried again: network errors and server errors. Client errors such as
+// 400, 403 or 413, or failing to build the request, never will.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryAfter returns the wait requested by the server along with err, if any
+func retryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter reads the Retry-After header of 429 and 503 responses,
+// which is either a number of seconds or an HTTP date.
+func parseRetryAfter(response *http.Response) time.Duration {
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// retryPolicy returns the configured RetryPolicy, or one built from
+// RetryCount and RetryWait.
+func (c *Client) retryPolicy() RetryPolicy {
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy
+	}
+	return &ConstantBackoff{MaxAttempts: c.RetryCount, Wait: c.RetryWait}
+}
+
+// retry calls send until it succeeds, the RetryPolicy gives up, or ctx is
+// done. onRetry, if not nil, is called before waiting for every retry. It
+// returns the number of attempts made and the error of the last one, along
+// with ctx.Err() if ctx stopped the retries.
+func (c *Client) retry(ctx context.Context, send func() error, onRetry func(attempt int, wait time.Duration, err error)) (int, error) {
+	policy := c.retryPolicy()
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return attempt, nil
+		}
+
+		if ctx.Err() != nil {
+			return attempt, combineErrors([]error{err, ctx.Err()})
+		}
+
+		wait, ok := policy.Backoff(attempt, time.Since(start), err)
+		if !ok {
+			return attempt, err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, wait, err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, combineErrors([]error{err, ctx.Err()})
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, retryable(&APIError{StatusCode: http.StatusServiceUnavailable}))
+	assert.True(t, retryable(&APIError{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, retryable(&APIError{StatusCode: http.StatusRequestTimeout}))
+	assert.False(t, retryable(&APIError{StatusCode: http.StatusBadRequest}))
+	assert.False(t, retryable(&APIError{StatusCode: http.StatusForbidden}))
+	assert.False(t, retryable(&APIError{StatusCode: http.StatusRequestEntityTooLarge}))
+	assert.False(t, retryable(errors.New("NRQL query is too short")))
+}
+
+func TestConstantBackoff(t *testing.T) {
+	p := &ConstantBackoff{MaxAttempts: 3, Wait: time.Second}
+	serverErr := &APIError{StatusCode: http.StatusBadGateway}
+
+	wait, ok := p.Backoff(1, 0, serverErr)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	_, ok = p.Backoff(3, 0, serverErr)
+	assert.False(t, ok, "Should stop after MaxAttempts")
+
+	_, ok = p.Backoff(1, 0, &APIError{StatusCode: http.StatusBadRequest})
+	assert.False(t, ok, "Client errors should not be retried")
+
+	wait, ok = p.Backoff(1, 0, &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute})
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, wait, "Retry-After should be honored")
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	p := &ExponentialBackoff{
+		MaxAttempts:     10,
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		MaxElapsedTime:  time.Minute,
+	}
+	serverErr := &APIError{StatusCode: http.StatusServiceUnavailable}
+
+	for attempt := 1; attempt < 10; attempt++ {
+		ceiling := 100 * time.Millisecond << uint(attempt-1)
+		if ceiling > time.Second {
+			ceiling = time.Second
+		}
+
+		wait, ok := p.Backoff(attempt, 0, serverErr)
+		assert.True(t, ok)
+		assert.True(t, wait >= 0 && wait <= ceiling, "wait %s should be within [0, %s]", wait, ceiling)
+	}
+
+	_, ok := p.Backoff(10, 0, serverErr)
+	assert.False(t, ok, "Should stop after MaxAttempts")
+
+	_, ok = p.Backoff(1, 2*time.Minute, serverErr)
+	assert.False(t, ok, "Should stop after MaxElapsedTime")
+
+	wait, ok := p.Backoff(1, 0, &APIError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 30 * time.Second})
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, wait, "Retry-After should be honored")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Retry-After", "120")
+	recorder.WriteHeader(http.StatusTooManyRequests)
+	assert.Equal(t, 2*time.Minute, parseRetryAfter(recorder.Result()))
+
+	recorder = httptest.NewRecorder()
+	recorder.Header().Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	recorder.WriteHeader(http.StatusServiceUnavailable)
+	wait := parseRetryAfter(recorder.Result())
+	assert.True(t, wait > 59*time.Minute && wait <= time.Hour)
+
+	// Only meaningful for 429 and 503
+	recorder = httptest.NewRecorder()
+	recorder.Header().Set("Retry-After", "120")
+	recorder.WriteHeader(http.StatusInternalServerError)
+	assert.Equal(t, time.Duration(0), parseRetryAfter(recorder.Result()))
+}
+
+func TestClientRetry(t *testing.T) {
+	c := &Client{RetryPolicy: &ConstantBackoff{MaxAttempts: 3, Wait: time.Millisecond}}
+	serverErr := &APIError{StatusCode: http.StatusInternalServerError}
+
+	calls := 0
+	attempts, err := c.retry(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return serverErr
+		}
+		return nil
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	retries := 0
+	attempts, err = c.retry(context.Background(), func() error { return serverErr }, func(int, time.Duration, error) { retries++ })
+	assert.Equal(t, serverErr, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 2, retries)
+
+	// Cancelling the context stops waiting
+	c.RetryPolicy = &ConstantBackoff{MaxAttempts: 3, Wait: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	attempts, err = c.retry(ctx, func() error { return serverErr }, nil)
+	assert.True(t, errors.Is(err, serverErr), "The last error should be returned")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "The context error should be returned")
+	assert.Equal(t, 1, attempts)
+}
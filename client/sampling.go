@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampleRateAttribute is added to sampled events, holding the fraction of
+// events of their eventType that were kept
+const sampleRateAttribute = "sampleRate"
+
+var sampleRateKey = []byte(`"` + sampleRateAttribute + `"`)
+
+// samplingWindow is the period over which adaptive sampling counts events
+const samplingWindow = time.Minute
+
+// SamplingRule decides how many events of an eventType EnqueueEvent keeps.
+// Kept events get a sampleRate attribute, the fraction of events that were
+// kept, so queries can re-weight counts, e.g. sum(1 / sampleRate).
+type SamplingRule struct {
+	// Rate is the fraction of events kept, from 0 (none) to 1 (all)
+	Rate float64
+	// TargetPerMinute, when set, adapts the rate every minute so that about
+	// this many events are kept per minute, based on how many were seen in
+	// the previous minute. Every event is kept during the first minute.
+	TargetPerMinute int
+}
+
+// sampler holds the state of adaptive sampling for every eventType
+type sampler struct {
+	mu      sync.Mutex
+	rand    *rand.Rand
+	windows map[string]*samplingWindowState
+}
+
+// samplingWindowState counts the events of an eventType over a window
+type samplingWindowState struct {
+	start time.Time
+	seen  int
+	rate  float64
+}
+
+// sampleEvent decides whether an enqueued event is kept, adding the
+// sampleRate attribute to kept events that were sampled.
+func (c *InsertClient) sampleEvent(data interface{}, jsonData []byte) ([]byte, bool) {
+	if len(c.Sampling) == 0 {
+		return jsonData, true
+	}
+
+	eventType, ok := eventTypeOf(data, jsonData)
+	if !ok {
+		return jsonData, true
+	}
+	rule, ok := c.Sampling[eventType]
+	if !ok {
+		return jsonData, true
+	}
+
+	rate, keep := c.sampler.sample(eventType, rule, time.Now())
+	if !keep {
+		atomic.AddInt64(&c.Statistics.SampledOutEventCount, 1)
+		return nil, false
+	}
+	if rate < 1 {
+		jsonData = addSampleRate(jsonData, rate)
+	}
+	return jsonData, true
+}
+
+// sample returns the rate for eventType and whether this event is kept
+func (s *sampler) sample(eventType string, rule SamplingRule, now time.Time) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := rule.Rate
+	if rule.TargetPerMinute > 0 {
+		rate = s.adaptiveRate(eventType, rule.TargetPerMinute, now)
+	}
+
+	switch {
+	case rate >= 1:
+		return 1, true
+	case rate <= 0:
+		return 0, false
+	}
+
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(time.Now().UnixNano())) // #nosec G404 -- sampling does not need a secure source
+	}
+	return rate, s.rand.Float64() < rate
+}
+
+// adaptiveRate counts the event in the current window of eventType, and
+// returns the rate worked out from the previous window.
+func (s *sampler) adaptiveRate(eventType string, target int, now time.Time) float64 {
+	if s.windows == nil {
+		s.windows = make(map[string]*samplingWindowState)
+	}
+
+	window, ok := s.windows[eventType]
+	if !ok {
+		window = &samplingWindowState{start: now, rate: 1}
+		s.windows[eventType] = window
+	}
+
+	if elapsed := now.Sub(window.start); elapsed >= samplingWindow {
+		window.rate = 1
+		// Nothing was seen in the last window if more than one went by
+		if elapsed < 2*samplingWindow && window.seen > target {
+			window.rate = float64(target) / float64(window.seen)
+		}
+		window.start = now
+		window.seen = 0
+	}
+
+	window.seen++
+	return window.rate
+}
+
+// eventTypeOf finds the eventType of an event, preferably without decoding
+// its JSON
+func eventTypeOf(data interface{}, jsonData []byte) (string, bool) {
+	switch event := data.(type) {
+	case EventTyper:
+		return event.EventType(), true
+	case map[string]interface{}:
+		eventType, ok := event["eventType"].(string)
+		return eventType, ok
+	}
+
+	var typed struct {
+		EventType *string `json:"eventType"`
+	}
+	if err := json.Unmarshal(jsonData, &typed); err != nil || typed.EventType == nil {
+		return "", false
+	}
+	return *typed.EventType, true
+}
+
+// addSampleRate adds the sampleRate attribute to a JSON object, unless the
+// event already sets it
+func addSampleRate(jsonData []byte, rate float64) []byte {
+	start := skipJSONSpace(jsonData, 0)
+	end := bytes.LastIndexByte(jsonData, '}')
+	if start >= len(jsonData) || jsonData[start] != '{' || end < start {
+		return jsonData
+	}
+	if containsKey(objectKeys(jsonData[start:end]), sampleRateKey) {
+		return jsonData
+	}
+
+	annotated := make([]byte, 0, len(jsonData)+32)
+	annotated = append(annotated, jsonData[:end]...)
+	if trimmed := bytes.TrimSpace(jsonData[:end]); len(trimmed) > 0 && trimmed[len(trimmed)-1] != '{' {
+		annotated = append(annotated, ',')
+	}
+	annotated = append(annotated, `"`+sampleRateAttribute+`":`...)
+	annotated = strconv.AppendFloat(annotated, rate, 'g', -1, 64)
+	return append(annotated, jsonData[end:]...)
+}
@@ -0,0 +1,86 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleFixedRate(t *testing.T) {
+	s := &sampler{}
+	now := time.Now()
+
+	_, keep := s.sample("test", SamplingRule{Rate: 0}, now)
+	assert.False(t, keep)
+	rate, keep := s.sample("test", SamplingRule{Rate: 1}, now)
+	assert.True(t, keep)
+	assert.Equal(t, 1.0, rate)
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if _, keep := s.sample("test", SamplingRule{Rate: 0.25}, now); keep {
+			kept++
+		}
+	}
+	assert.InDelta(t, 2500, kept, 300, "About a quarter of the events should be kept")
+}
+
+func TestSampleAdaptive(t *testing.T) {
+	s := &sampler{}
+	rule := SamplingRule{TargetPerMinute: 100}
+	start := time.Now()
+
+	// Everything is kept in the first window
+	for i := 0; i < 1000; i++ {
+		rate, keep := s.sample("test", rule, start)
+		assert.True(t, keep)
+		assert.Equal(t, 1.0, rate)
+	}
+
+	rate, _ := s.sample("test", rule, start.Add(samplingWindow))
+	assert.Equal(t, 0.1, rate, "Rate should come from the events seen in the previous window")
+
+	// Other event types are counted on their own
+	rate, _ = s.sample("other", rule, start.Add(samplingWindow))
+	assert.Equal(t, 1.0, rate)
+
+	// A quiet window resets the rate
+	rate, _ = s.sample("test", rule, start.Add(5*samplingWindow))
+	assert.Equal(t, 1.0, rate)
+}
+
+func TestAddSampleRate(t *testing.T) {
+	assert.Equal(t, `{"eventType":"test","sampleRate":0.5}`, string(addSampleRate([]byte(`{"eventType":"test"}`), 0.5)))
+	assert.Equal(t, `{ "sampleRate":0.5}`, string(addSampleRate([]byte(`{ }`), 0.5)))
+	assert.Equal(t, `{"eventType":"test","sampleRate":1}`, string(addSampleRate([]byte(`{"eventType":"test","sampleRate":1}`), 0.5)), "Existing values should be kept")
+	assert.Equal(t, `{"eventType":"test","note":"sampleRate","sampleRate":0.5}`, string(addSampleRate([]byte(`{"eventType":"test","note":"sampleRate"}`), 0.5)), "Only keys count")
+	assert.Equal(t, `{"eventType":"test","nested":{"sampleRate":1},"sampleRate":0.5}`, string(addSampleRate([]byte(`{"eventType":"test","nested":{"sampleRate":1}}`), 0.5)), "Only top-level keys count")
+}
+
+func TestInsertEnqueue_sampling(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.Sampling = map[string]SamplingRule{
+		"dropped": {Rate: 0},
+		"sampled": {Rate: 0.999999999},
+	}
+	client.eventQueue = make(chan []byte, 10)
+	client.quit = make(chan struct{})
+
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "dropped"}))
+	assert.NoError(t, client.EnqueueEvent(NewEvent("dropped")))
+	assert.NoError(t, client.EnqueueEvent(struct {
+		EventType string `json:"eventType"`
+	}{EventType: "dropped"}))
+	assert.Equal(t, int64(3), client.Statistics.SampledOutEventCount)
+	assert.Equal(t, 0, len(client.eventQueue))
+
+	assert.NoError(t, client.EnqueueEvent(NewEvent("sampled")))
+	assert.Equal(t, `{"eventType":"sampled","sampleRate":0.999999999}`, string(<-client.eventQueue))
+
+	assert.NoError(t, client.EnqueueEventContext(context.Background(), NewEvent("kept")))
+	assert.Equal(t, `{"eventType":"kept"}`, string(<-client.eventQueue), "Other event types should not be sampled")
+}
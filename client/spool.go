@@ -0,0 +1,456 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolSegmentExt = ".batch"
+	spoolTempExt    = ".tmp"
+
+	// each record is a uint32 length and a uint32 CRC32 followed by the event
+	spoolRecordHeaderLen = 8
+)
+
+// errSpoolCorrupt is returned when a segment can not be decoded
+var errSpoolCorrupt = errors.New("corrupt spool segment")
+
+// spoolSegment is a single batch persisted to disk
+type spoolSegment struct {
+	name string
+	size int64
+}
+
+// spool stores batches that failed to send as segment files in a directory,
+// one batch per file, so that they survive outages and restarts. Files are
+// written to a temporary name and renamed into place, so a crash never
+// leaves a partially written segment behind.
+type spool struct {
+	dir      string
+	maxBytes int64
+	logger   *log.Logger
+	stats    *Statistics
+
+	mu       sync.Mutex
+	segments []spoolSegment // oldest first
+	size     int64
+	seq      uint64
+}
+
+// openSpool prepares dir for use as a spool, recovering any segments left
+// behind by a previous run.
+func openSpool(dir string, maxBytes int64, logger *log.Logger, stats *Statistics) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		logger:   logger,
+		stats:    stats,
+	}
+
+	for _, f := range files {
+		switch {
+		case f.IsDir():
+			continue
+		case strings.HasSuffix(f.Name(), spoolTempExt):
+			// Interrupted write, the batch was never committed
+			if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				logger.Warnf("failed to remove incomplete spool file %s: %v", f.Name(), err)
+			}
+		case strings.HasSuffix(f.Name(), spoolSegmentExt):
+			s.segments = append(s.segments, spoolSegment{name: f.Name(), size: f.Size()})
+			s.size += f.Size()
+		}
+	}
+
+	// Names start with a timestamp, so this is oldest first
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].name < s.segments[j].name
+	})
+	s.evict(0)
+
+	if len(s.segments) > 0 {
+		logger.Infof("recovered %d spooled insights batches (%d bytes) from %s", len(s.segments), s.size, dir)
+	}
+
+	return s, nil
+}
+
+// write persists a batch of events as a new segment, evicting the oldest
+// segments if needed to stay under maxBytes.
+func (s *spool) write(events [][]byte) error {
+	data := encodeSpoolSegment(events)
+	size := int64(len(data))
+	if size > s.maxBytes {
+		return fmt.Errorf("batch of %d bytes is larger than the spool", size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(size)
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolSegmentExt)
+	tmpName := filepath.Join(s.dir, name+spoolTempExt)
+
+	if err := writeFileSync(tmpName, data); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	if err := os.Rename(tmpName, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to commit spool segment: %w", err)
+	}
+
+	s.segments = append(s.segments, spoolSegment{name: name, size: size})
+	s.size += size
+
+	return nil
+}
+
+// evict removes the oldest segments until there is room for needed bytes.
+// Must be called with s.mu held.
+func (s *spool) evict(needed int64) {
+	for len(s.segments) > 0 && s.size+needed > s.maxBytes {
+		oldest := s.segments[0]
+		if err := os.Remove(filepath.Join(s.dir, oldest.name)); err != nil && !os.IsNotExist(err) {
+			s.logger.Warnf("failed to evict spool segment %s: %v", oldest.name, err)
+		}
+		s.segments = s.segments[1:]
+		s.size -= oldest.size
+		atomic.AddInt64(&s.stats.SpoolEvictedCount, 1)
+		s.logger.Warnf("spool is full, evicted oldest insights batch %s", oldest.name)
+	}
+}
+
+// oldest returns the name and events of the oldest segment. An empty name
+// means the spool is empty.
+func (s *spool) oldest() (string, [][]byte, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return "", nil, nil
+	}
+	name := s.segments[0].name
+	s.mu.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name)) // #nosec G304 -- name comes from our own directory listing
+	if err != nil {
+		return name, nil, err
+	}
+
+	events, err := decodeSpoolSegment(data)
+	return name, events, err
+}
+
+// remove deletes a segment, after it was sent or found to be unusable
+func (s *spool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.segments {
+		if s.segments[i].name == name {
+			s.size -= s.segments[i].size
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// len returns the number of batches in the spool
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.segments)
+}
+
+// encodeSpoolSegment serializes events as length and checksum prefixed records
+func encodeSpoolSegment(events [][]byte) []byte {
+	size := 0
+	for _, e := range events {
+		size += spoolRecordHeaderLen + len(e)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	header := make([]byte, spoolRecordHeaderLen)
+	for _, e := range events {
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(e)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(e))
+		buf.Write(header)
+		buf.Write(e)
+	}
+
+	return buf.Bytes()
+}
+
+// decodeSpoolSegment reverses encodeSpoolSegment, validating every record
+func decodeSpoolSegment(data []byte) ([][]byte, error) {
+	var events [][]byte
+	reader := bytes.NewReader(data)
+	header := make([]byte, spoolRecordHeaderLen)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errSpoolCorrupt
+		}
+
+		event := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(reader, event); err != nil {
+			return nil, errSpoolCorrupt
+		}
+		if crc32.ChecksumIEEE(event) != binary.BigEndian.Uint32(header[4:8]) {
+			return nil, errSpoolCorrupt
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil, errSpoolCorrupt
+	}
+
+	return events, nil
+}
+
+// writeFileSync writes data to a new file and makes sure it reached the disk
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// spoolBatch persists a batch that could not be sent. It reports whether
+// the batch was saved.
+func (c *InsertClient) spoolBatch(events [][]byte) bool {
+	if c.spool == nil {
+		return false
+	}
+
+	if err := c.spool.write(events); err != nil {
+		c.Logger.Errorf("Failed to spool insights events: %v", err)
+		return false
+	}
+
+	atomic.AddInt64(&c.Statistics.SpooledBatchCount, 1)
+	c.Logger.Warnf("Spooled %d insights events to %s for later delivery", len(events), c.spool.dir)
+	return true
+}
+
+// spill hands an event to the spill worker, without blocking. It reports
+// false if the spill worker is not running or already has BatchSize events
+// waiting.
+func (c *InsertClient) spill(event []byte) bool {
+	c.stateMu.RLock()
+	spillQueue := c.spillQueue
+	c.stateMu.RUnlock()
+
+	select {
+	case spillQueue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// spillWorker writes the events spilled because the queue was full to the
+// spool, until the client is stopped. Events spilled while a batch is being
+// written are written together as the next one, so spilling costs a single
+// write per batch rather than per event.
+func (c *InsertClient) spillWorker() error {
+	batch := &eventBatch{events: make([][]byte, c.BatchSize)}
+	for {
+		select {
+		case event := <-c.spillQueue:
+			c.addToSpill(batch, event)
+			c.drainSpill(batch)
+		case <-c.stop:
+			// Nobody can spill anymore, write whatever is left
+			c.drainSpill(batch)
+			return nil
+		}
+	}
+}
+
+// drainSpill adds every event waiting in the spill queue to the batch, and
+// writes it.
+func (c *InsertClient) drainSpill(batch *eventBatch) {
+	for {
+		select {
+		case event := <-c.spillQueue:
+			c.addToSpill(batch, event)
+		default:
+			c.writeSpill(batch)
+			return
+		}
+	}
+}
+
+// addToSpill appends an event to the spilled batch, writing the batch first
+// if the event would push it over MaxBatchBytes, and afterwards if it is
+// full, so spooled batches can be replayed as they are.
+func (c *InsertClient) addToSpill(batch *eventBatch, event []byte) {
+	if batch.count > 0 && c.exceedsMaxBatchBytes(batch.bytes+len(event)+1) {
+		c.writeSpill(batch)
+	}
+
+	if batch.count == 0 {
+		batch.bytes = 2 // []
+	} else {
+		batch.bytes++ // ,
+	}
+	batch.events[batch.count] = event
+	batch.count++
+	batch.bytes += len(event)
+
+	if batch.count >= len(batch.events) {
+		c.writeSpill(batch)
+	}
+}
+
+// writeSpill writes the spilled batch to the spool, dropping its events if
+// that fails.
+func (c *InsertClient) writeSpill(batch *eventBatch) {
+	if batch.count == 0 {
+		return
+	}
+
+	events := batch.events[:batch.count]
+	if c.spoolBatch(events) {
+		atomic.AddInt64(&c.Statistics.SpilledEventCount, int64(len(events)))
+	} else {
+		for _, event := range events {
+			c.dropEvent(event)
+		}
+	}
+
+	for i := range events {
+		events[i] = nil
+	}
+	batch.count = 0
+	batch.bytes = 0
+}
+
+// replayWorker resends the batches stored in the spool every interval until
+// the client is closed.
+func (c *InsertClient) replayWorker(ctx context.Context, interval time.Duration) error {
+	if c.spool == nil {
+		return errors.New("spool is not enabled")
+	}
+
+	// A replay in progress is abandoned as soon as the client closes, Close
+	// would otherwise wait on it
+	quit := c.quit
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Deliver whatever was left over from a previous run right away
+	c.replaySpool(ctx)
+
+	for {
+		select {
+		case <-ticker.C:
+			c.replaySpool(ctx)
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+// replaySpool sends spooled batches, oldest first, until the spool is empty,
+// a send fails, or ctx is done.
+func (c *InsertClient) replaySpool(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		name, events, err := c.spool.oldest()
+		if name == "" {
+			return
+		}
+		if err != nil {
+			c.Logger.Errorf("Discarding unreadable spool segment %s: %v", name, err)
+			if rmErr := c.spool.remove(name); rmErr != nil {
+				c.Logger.Errorf("Failed to remove spool segment %s: %v", name, rmErr)
+				return
+			}
+			continue
+		}
+
+		if sendErr := c.sendEvents(ctx, events); sendErr != nil {
+			if ctx.Err() != nil || spoolable(sendErr) {
+				c.Logger.Debugf("Insights is still unavailable, %d batches remain spooled: %v", c.spool.len(), sendErr)
+				return
+			}
+
+			// Insights will never accept this batch
+			c.reportError(&BatchError{
+				Events:     events,
+				Attempts:   1,
+				StatusCode: statusCode(sendErr),
+				Err:        sendErr,
+			})
+		} else {
+			atomic.AddInt64(&c.Statistics.ReplayedBatchCount, 1)
+		}
+
+		if rmErr := c.spool.remove(name); rmErr != nil {
+			c.Logger.Errorf("Failed to remove spool segment %s: %v", name, rmErr)
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSpoolDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "go-insights-spool")
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestSpoolWriteAndRead(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	stats := &Statistics{}
+	s, err := openSpool(dir, DefaultSpoolMaxBytes, log.New(), stats)
+	assert.NoError(t, err)
+
+	batch := [][]byte{[]byte(testInsertJSONString), []byte(`{"eventType":"test","num":1}`)}
+	assert.NoError(t, s.write(batch))
+	assert.NoError(t, s.write(batch[1:]))
+	assert.Equal(t, 2, s.len())
+
+	name, events, err := s.oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, batch, events, "Oldest batch should come back first")
+
+	assert.NoError(t, s.remove(name))
+	assert.Equal(t, 1, s.len())
+
+	_, events, err = s.oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, batch[1:], events)
+}
+
+func TestSpoolEviction(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	batch := [][]byte{[]byte(testInsertJSONString)}
+	segmentSize := int64(len(encodeSpoolSegment(batch)))
+
+	stats := &Statistics{}
+	s, err := openSpool(dir, 2*segmentSize, log.New(), stats)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.write(batch))
+	}
+	assert.Equal(t, 2, s.len(), "Spool should not grow past its maximum size")
+	assert.Equal(t, int64(1), stats.SpoolEvictedCount)
+
+	// Too big to ever fit
+	err = s.write([][]byte{make([]byte, 3*segmentSize)})
+	assert.Error(t, err)
+}
+
+func TestSpoolRecovery(t *testing.T) {
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	stats := &Statistics{}
+	s, err := openSpool(dir, DefaultSpoolMaxBytes, log.New(), stats)
+	assert.NoError(t, err)
+	assert.NoError(t, s.write([][]byte{[]byte(testInsertJSONString)}))
+
+	// Leftovers of a crash
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1"+spoolSegmentExt+spoolTempExt), []byte("partial"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "0"+spoolSegmentExt), []byte("garbage"), 0600))
+
+	s, err = openSpool(dir, DefaultSpoolMaxBytes, log.New(), stats)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.len(), "Committed segments should be recovered")
+
+	_, err = os.Stat(filepath.Join(dir, "1"+spoolSegmentExt+spoolTempExt))
+	assert.True(t, os.IsNotExist(err), "Incomplete writes should be removed")
+
+	// Segments sort by name, so the garbage one is first
+	_, _, err = s.oldest()
+	assert.Equal(t, errSpoolCorrupt, err)
+}
+
+func TestInsertSpoolAndReplay(t *testing.T) {
+	var err error
+	var available int32
+
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			testHandlerBad.ServeHTTP(w, r)
+			return
+		}
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryCount = 1
+	client.BatchSize = 1
+	client.SpoolDir = dir
+	client.SpoolReplayInterval = 50 * time.Millisecond
+	client.OnError = func(err error) { t.Errorf("Spooled batches should not be reported: %v", err) }
+
+	assert.NoError(t, client.Start())
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test"}))
+
+	for i := 0; i < 100 && atomic.LoadInt64(&client.Statistics.SpooledBatchCount) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&client.Statistics.SpooledBatchCount))
+
+	atomic.StoreInt32(&available, 1)
+	for i := 0; i < 100 && atomic.LoadInt64(&client.Statistics.ReplayedBatchCount) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&client.Statistics.ReplayedBatchCount))
+	assert.Equal(t, 0, client.spool.len())
+	assert.NoError(t, client.Close(context.Background()))
+}
+
+func TestInsertSpoolZeroConfig(t *testing.T) {
+	var err error
+
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RetryCount = 1
+	client.BatchSize = 1
+	client.SpoolDir = dir
+	client.SpoolMaxBytes = 0
+	client.SpoolReplayInterval = 0
+	client.OnError = func(err error) { t.Errorf("Spooled batches should not be reported: %v", err) }
+
+	assert.NoError(t, client.Start(), "Zero spool settings should fall back to the defaults")
+	assert.NoError(t, client.EnqueueEvent(map[string]interface{}{"eventType": "test"}))
+
+	for i := 0; i < 100 && atomic.LoadInt64(&client.Statistics.SpooledBatchCount) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&client.Statistics.SpooledBatchCount))
+	assert.NoError(t, client.Close(context.Background()))
+}
+
+func TestInsertSpoolReplay_close(t *testing.T) {
+	var err error
+
+	dir := testSpoolDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, DefaultSpoolMaxBytes, log.New(), &Statistics{})
+	assert.NoError(t, err)
+	assert.NoError(t, s.write([][]byte{[]byte(`{"eventType":"test"}`)}))
+
+	received, release := make(chan struct{}, 1), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := NewInsertClient(testKey, testID) // Create test client
+	client.URL, err = client.URL.Parse(ts.URL) // Override the URL
+	assert.NoError(t, err)
+	client.RequestTimeout = time.Hour
+	client.SpoolDir = dir
+	client.OnError = func(err error) { t.Errorf("Interrupted replays should not be reported: %v", err) }
+
+	assert.NoError(t, client.Start())
+	<-received // The replay hangs
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, client.Close(ctx), "Close should interrupt the replay")
+	assert.Equal(t, 1, client.spool.len(), "The interrupted batch should stay spooled")
+	assert.NoError(t, client.Start(), "The client should be shut down")
+	assert.NoError(t, client.Close(context.Background()))
+}
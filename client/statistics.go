@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// StatisticsRates are the rates at which the client processed data over
+// an interval.
+type StatisticsRates struct {
+	// Interval is the time the rates were computed over
+	Interval time.Duration
+	// EventsPerSecond is the rate of events added with EnqueueEvent
+	EventsPerSecond float64
+	// ProcessedEventsPerSecond is the rate of events that finished processing
+	ProcessedEventsPerSecond float64
+	// BytesPerSecond is the rate of uncompressed bytes sent to Insights
+	BytesPerSecond float64
+}
+
+// Snapshot returns a copy of the Statistics. Every field is read
+// atomically, so it is safe to call while the client is running.
+func (c *InsertClient) Snapshot() Statistics {
+	s := &c.Statistics
+	load := atomic.LoadInt64
+
+	return Statistics{
+		EventCount:           load(&s.EventCount),
+		ProcessedEventCount:  load(&s.ProcessedEventCount),
+		FlushCount:           load(&s.FlushCount),
+		ByteCount:            load(&s.ByteCount),
+		FullFlushCount:       load(&s.FullFlushCount),
+		PartialFlushCount:    load(&s.PartialFlushCount),
+		TimerExpiredCount:    load(&s.TimerExpiredCount),
+		InsightsRetryCount:   load(&s.InsightsRetryCount),
+		HTTPErrorCount:       load(&s.HTTPErrorCount),
+		SpooledBatchCount:    load(&s.SpooledBatchCount),
+		ReplayedBatchCount:   load(&s.ReplayedBatchCount),
+		SpoolEvictedCount:    load(&s.SpoolEvictedCount),
+		InFlightBatchCount:   load(&s.InFlightBatchCount),
+		WaitingBatchCount:    load(&s.WaitingBatchCount),
+		SplitBatchCount:      load(&s.SplitBatchCount),
+		BufferedBytes:        load(&s.BufferedBytes),
+		SampledOutEventCount: load(&s.SampledOutEventCount),
+		DroppedEventCount:    load(&s.DroppedEventCount),
+		SpilledEventCount:    load(&s.SpilledEventCount),
+		RateLimitedCount:     load(&s.RateLimitedCount),
+		RateLimitWaitTime:    load(&s.RateLimitWaitTime),
+		CircuitStateValue:    load(&s.CircuitStateValue),
+		CircuitOpenCount:     load(&s.CircuitOpenCount),
+		CircuitRejectedCount: load(&s.CircuitRejectedCount),
+	}
+}
+
+// ResetStatistics sets every counter in the Statistics back to zero, along
+// with the send latencies and HTTP errors. Fields describing the current
+// state of the client, InFlightBatchCount, WaitingBatchCount,
+// BufferedBytes and CircuitStateValue, are kept.
+func (c *InsertClient) ResetStatistics() {
+	s := &c.Statistics
+	for _, counter := range []*int64{
+		&s.EventCount,
+		&s.ProcessedEventCount,
+		&s.FlushCount,
+		&s.ByteCount,
+		&s.FullFlushCount,
+		&s.PartialFlushCount,
+		&s.TimerExpiredCount,
+		&s.InsightsRetryCount,
+		&s.HTTPErrorCount,
+		&s.SpooledBatchCount,
+		&s.ReplayedBatchCount,
+		&s.SpoolEvictedCount,
+		&s.SplitBatchCount,
+		&s.SampledOutEventCount,
+		&s.DroppedEventCount,
+		&s.SpilledEventCount,
+		&s.RateLimitedCount,
+		&s.RateLimitWaitTime,
+		&s.CircuitOpenCount,
+		&s.CircuitRejectedCount,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+	c.sendMetrics.reset()
+
+	c.ratesMu.Lock()
+	c.ratesPrev, c.ratesAt = Statistics{}, time.Now()
+	c.ratesMu.Unlock()
+}
+
+// Rates returns the rates at which the client processed data since the
+// previous call to Rates or ResetStatistics. The first call returns zero
+// rates and starts the interval.
+func (c *InsertClient) Rates() StatisticsRates {
+	now := time.Now()
+	current := c.Snapshot()
+
+	c.ratesMu.Lock()
+	defer c.ratesMu.Unlock()
+
+	prev, since := c.ratesPrev, c.ratesAt
+	c.ratesPrev, c.ratesAt = current, now
+	if since.IsZero() {
+		return StatisticsRates{}
+	}
+
+	interval := now.Sub(since)
+	perSecond := func(cur, prev int64) float64 {
+		if interval <= 0 {
+			return 0
+		}
+		return float64(cur-prev) / interval.Seconds()
+	}
+
+	return StatisticsRates{
+		Interval:                 interval,
+		EventsPerSecond:          perSecond(current.EventCount, prev.EventCount),
+		ProcessedEventsPerSecond: perSecond(current.ProcessedEventCount, prev.ProcessedEventCount),
+		BytesPerSecond:           perSecond(current.ByteCount, prev.ByteCount),
+	}
+}
+
+// HTTPErrors returns the number of failed requests to Insights by cause:
+// the HTTP status code when a response was received, otherwise the class of
+// network error ("timeout", "dns", "connection_refused", "connection_reset",
+// "tls" or "network"), or "other" for any other failure. The counts add up
+// to Statistics.HTTPErrorCount.
+func (c *InsertClient) HTTPErrors() map[string]int64 {
+	_, failures := c.sendMetrics.snapshot()
+	return failures
+}
+
+// failureLabel is the cause a failed request is counted under
+func failureLabel(err error) string {
+	if code := statusCode(err); code != 0 {
+		return strconv.Itoa(code)
+	}
+	return networkErrorClass(err)
+}
+
+// networkErrorClass classifies an error returned without any response
+func networkErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var recordHeader tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert),
+		errors.As(err, &hostname), errors.As(err, &recordHeader):
+		return "tls"
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
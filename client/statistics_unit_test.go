@@ -0,0 +1,114 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertSnapshot(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	client.Statistics.EventCount = 5
+	client.Statistics.BufferedBytes = 10
+
+	snapshot := client.Snapshot()
+	client.Statistics.EventCount = 6
+	assert.Equal(t, int64(5), snapshot.EventCount, "A snapshot is a copy")
+	assert.Equal(t, int64(10), snapshot.BufferedBytes)
+}
+
+func TestInsertResetStatistics(t *testing.T) {
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	assert.Error(t, client.sendEvents(context.Background(), testInsertJSON))
+	client.Statistics.DroppedEventCount = 2
+	client.Statistics.InFlightBatchCount = 1
+	client.Statistics.BufferedBytes = 100
+
+	client.ResetStatistics()
+	snapshot := client.Snapshot()
+	assert.Equal(t, int64(0), snapshot.ByteCount)
+	assert.Equal(t, int64(0), snapshot.HTTPErrorCount)
+	assert.Equal(t, int64(0), snapshot.DroppedEventCount)
+	assert.Equal(t, int64(1), snapshot.InFlightBatchCount, "Gauges are not reset")
+	assert.Equal(t, int64(100), snapshot.BufferedBytes, "Gauges are not reset")
+	assert.Empty(t, client.HTTPErrors())
+}
+
+func TestInsertRates(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	assert.Equal(t, StatisticsRates{}, client.Rates(), "The first call starts the interval")
+
+	client.Statistics.EventCount = 100
+	client.Statistics.ByteCount = 4000
+	time.Sleep(100 * time.Millisecond)
+
+	rates := client.Rates()
+	assert.True(t, rates.Interval >= 100*time.Millisecond)
+	assert.InDelta(t, 100/rates.Interval.Seconds(), rates.EventsPerSecond, 0.001)
+	assert.InDelta(t, 4000/rates.Interval.Seconds(), rates.BytesPerSecond, 0.001)
+	assert.Equal(t, float64(0), rates.ProcessedEventsPerSecond)
+
+	rates = client.Rates()
+	assert.Equal(t, float64(0), rates.EventsPerSecond, "Rates only cover the last interval")
+}
+
+func TestInsertHTTPErrors(t *testing.T) {
+	ts := httptest.NewServer(testHandlerBad)
+	defer ts.Close()
+	closed := httptest.NewServer(testHandlerBad)
+	closed.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	assert.Error(t, client.sendEvents(context.Background(), testInsertJSON))
+	client.URL, _ = client.URL.Parse(closed.URL)
+	assert.Error(t, client.sendEvents(context.Background(), testInsertJSON))
+
+	assert.Equal(t, int64(2), client.Snapshot().HTTPErrorCount)
+	assert.Equal(t, map[string]int64{"503": 1, "connection_refused": 1}, client.HTTPErrors())
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNetworkErrorClass(t *testing.T) {
+	opErr := func(err error) error {
+		return fmt.Errorf("Insights Post: %w", &net.OpError{Op: "dial", Net: "tcp", Err: err})
+	}
+
+	for _, tc := range []struct {
+		class string
+		err   error
+	}{
+		{"dns", opErr(&net.DNSError{Err: "no such host", Name: "insights.invalid"})},
+		{"timeout", opErr(timeoutError{})},
+		{"timeout", context.DeadlineExceeded},
+		{"connection_refused", opErr(os.NewSyscallError("connect", syscall.ECONNREFUSED))},
+		{"connection_reset", opErr(os.NewSyscallError("read", syscall.ECONNRESET))},
+		{"connection_reset", fmt.Errorf("Insights Post: %w", io.EOF)},
+		{"tls", fmt.Errorf("Insights Post: %w", x509.UnknownAuthorityError{})},
+		{"network", opErr(errors.New("network is unreachable"))},
+		{"other", errors.New("failed to construct request")},
+	} {
+		assert.Equal(t, tc.class, networkErrorClass(tc.err), tc.err.Error())
+	}
+}
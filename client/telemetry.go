@@ -0,0 +1,123 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// SelfTelemetryEventType is the eventType of the events describing the
+// health of the client, see InsertClient.SelfTelemetryInterval
+const SelfTelemetryEventType = "GoInsightsClientStats"
+
+// telemetryState is what the previous self-telemetry event reported, so
+// the next one can report the changes since
+type telemetryState struct {
+	at         time.Time
+	stats      Statistics
+	sendCount  int64
+	sendTotal  float64
+	httpErrors map[string]int64
+}
+
+// telemetryWorker enqueues a self-telemetry event every
+// SelfTelemetryInterval until the client is closed.
+func (c *InsertClient) telemetryWorker() error {
+	ticker := time.NewTicker(c.SelfTelemetryInterval)
+	defer ticker.Stop()
+
+	state := c.telemetryState(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			cur := c.telemetryState(now)
+			event := c.telemetryEvent(state, cur)
+			state = cur
+			if err := c.EnqueueEvent(event); errors.Is(err, ErrClientClosed) {
+				return nil
+			} else if err != nil {
+				c.Logger.Errorf("Failed to enqueue %s event: %v", SelfTelemetryEventType, err)
+			}
+		case <-c.quit:
+			return nil
+		}
+	}
+}
+
+// telemetryState captures the counters reported by self-telemetry
+func (c *InsertClient) telemetryState(now time.Time) telemetryState {
+	hist, httpErrors := c.sendMetrics.snapshot()
+	return telemetryState{
+		at:         now,
+		stats:      c.Snapshot(),
+		sendCount:  hist.Count,
+		sendTotal:  hist.Sum,
+		httpErrors: httpErrors,
+	}
+}
+
+// telemetryEvent builds the self-telemetry event covering the interval from
+// prev to cur. Counters are reported as the change over the interval, and
+// gauges as their current value.
+func (c *InsertClient) telemetryEvent(prev, cur telemetryState) *Event {
+	// Counters start over after ResetStatistics
+	delta := func(cur, prev int64) int64 {
+		if cur < prev {
+			return cur
+		}
+		return cur - prev
+	}
+	p, s := &prev.stats, &cur.stats
+
+	event := NewEvent(SelfTelemetryEventType).
+		SetTimestamp(cur.at).
+		SetFloat("intervalSeconds", cur.at.Sub(prev.at).Seconds()).
+		SetInt("eventCount", delta(s.EventCount, p.EventCount)).
+		SetInt("processedEventCount", delta(s.ProcessedEventCount, p.ProcessedEventCount)).
+		SetInt("byteCount", delta(s.ByteCount, p.ByteCount)).
+		SetInt("flushCount", delta(s.FlushCount, p.FlushCount)).
+		SetInt("fullFlushCount", delta(s.FullFlushCount, p.FullFlushCount)).
+		SetInt("partialFlushCount", delta(s.PartialFlushCount, p.PartialFlushCount)).
+		SetInt("timerExpiredCount", delta(s.TimerExpiredCount, p.TimerExpiredCount)).
+		SetInt("retryCount", delta(s.InsightsRetryCount, p.InsightsRetryCount)).
+		SetInt("httpErrorCount", delta(s.HTTPErrorCount, p.HTTPErrorCount)).
+		SetInt("splitBatchCount", delta(s.SplitBatchCount, p.SplitBatchCount)).
+		SetInt("spooledBatchCount", delta(s.SpooledBatchCount, p.SpooledBatchCount)).
+		SetInt("replayedBatchCount", delta(s.ReplayedBatchCount, p.ReplayedBatchCount)).
+		SetInt("spoolEvictedCount", delta(s.SpoolEvictedCount, p.SpoolEvictedCount)).
+		SetInt("sampledOutEventCount", delta(s.SampledOutEventCount, p.SampledOutEventCount)).
+		SetInt("droppedEventCount", delta(s.DroppedEventCount, p.DroppedEventCount)).
+		SetInt("spilledEventCount", delta(s.SpilledEventCount, p.SpilledEventCount)).
+		SetInt("rateLimitedCount", delta(s.RateLimitedCount, p.RateLimitedCount)).
+		SetFloat("rateLimitWaitSeconds", time.Duration(delta(s.RateLimitWaitTime, p.RateLimitWaitTime)).Seconds()).
+		SetInt("circuitOpenCount", delta(s.CircuitOpenCount, p.CircuitOpenCount)).
+		SetInt("circuitRejectedCount", delta(s.CircuitRejectedCount, p.CircuitRejectedCount)).
+		SetString("circuitState", CircuitState(s.CircuitStateValue).String()).
+		SetInt("queuedEvents", int64(c.queueDepth())).
+		SetInt("waitingBatchCount", s.WaitingBatchCount).
+		SetInt("inFlightBatchCount", s.InFlightBatchCount).
+		SetInt("bufferedBytes", s.BufferedBytes)
+
+	sends := delta(cur.sendCount, prev.sendCount)
+	event.SetInt("sendCount", sends)
+	if sends > 0 {
+		total := cur.sendTotal
+		if cur.sendCount >= prev.sendCount {
+			total -= prev.sendTotal
+		}
+		event.SetFloat("sendDurationAverage", total/float64(sends))
+	}
+
+	causes := make([]string, 0, len(cur.httpErrors))
+	for cause := range cur.httpErrors {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		if d := delta(cur.httpErrors[cause], prev.httpErrors[cause]); d > 0 {
+			event.SetInt("httpErrors."+cause, d)
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,84 @@
+// +build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertTelemetryEvent(t *testing.T) {
+	client := NewInsertClient(testKey, testID)
+	start := time.Now()
+	prev := client.telemetryState(start)
+
+	client.Statistics.EventCount = 10
+	client.Statistics.DroppedEventCount = 2
+	client.Statistics.BufferedBytes = 300
+	client.sendMetrics.observe(100*time.Millisecond, nil)
+	client.sendMetrics.observe(300*time.Millisecond, &APIError{StatusCode: http.StatusServiceUnavailable})
+
+	data, err := client.marshalEvent(client.telemetryEvent(prev, client.telemetryState(start.Add(time.Minute))))
+	assert.NoError(t, err, "Self-telemetry events should be valid")
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, SelfTelemetryEventType, event["eventType"])
+	assert.Equal(t, float64(60), event["intervalSeconds"])
+	assert.Equal(t, float64(10), event["eventCount"])
+	assert.Equal(t, float64(2), event["droppedEventCount"])
+	assert.Equal(t, float64(300), event["bufferedBytes"])
+	assert.Equal(t, float64(2), event["sendCount"])
+	assert.InDelta(t, 0.2, event["sendDurationAverage"], 0.0001)
+	assert.Equal(t, float64(1), event["httpErrors.503"])
+
+	// Only changes are reported, and counters may start over
+	prev = client.telemetryState(start.Add(time.Minute))
+	client.ResetStatistics()
+	client.Statistics.EventCount = 3
+	event = map[string]interface{}{}
+	data, err = client.marshalEvent(client.telemetryEvent(prev, client.telemetryState(start.Add(2*time.Minute))))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, float64(3), event["eventCount"])
+	assert.Equal(t, float64(0), event["droppedEventCount"])
+	assert.Equal(t, float64(0), event["sendCount"])
+	assert.NotContains(t, event, "sendDurationAverage")
+	assert.NotContains(t, event, "httpErrors.503")
+}
+
+func TestInsertSelfTelemetry(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		testInsertHandlerSuccess.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewInsertClient(testKey, testID)
+	client.URL, _ = client.URL.Parse(ts.URL)
+	client.SelfTelemetryInterval = 10 * time.Millisecond
+	assert.NoError(t, client.Start())
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, client.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, bodies)
+	for _, body := range bodies {
+		assert.Contains(t, body, `"eventType":"`+SelfTelemetryEventType+`"`)
+	}
+}
@@ -1,7 +1,11 @@
 package client
 
 import (
+	"compress/flate"
+	"fmt"
+	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -11,6 +15,12 @@ const (
 	insightsInsertURL = "https://insights-collector.newrelic.com/v1/accounts"
 	insightsQueryURL  = "https://insights-api.newrelic.com/v1/accounts"
 
+	insightsInsertURLEU = "https://insights-collector.eu01.nr-data.net/v1/accounts"
+	insightsQueryURLEU  = "https://insights-api.eu.newrelic.com/v1/accounts"
+
+	insightsInsertURLFedRAMP = "https://gov-insights-collector.newrelic.com/v1/accounts"
+	insightsQueryURLFedRAMP  = "https://gov-insights-api.newrelic.com/v1/accounts"
+
 	// Minimum length check for a valid NRQL statement
 	minValidNRQLLength = 8 // "SELECT 1"
 
@@ -18,14 +28,34 @@ const (
 	DefaultBatchTimeout = 1 * time.Minute
 	// DefaultBatchEventCount is the maximum number of events before sending a batch (fuzzy)
 	DefaultBatchEventCount = 950
+	// DefaultMaxBatchBytes is the maximum size of an insert request accepted by Insights
+	DefaultMaxBatchBytes = 1000000
 	// DefaultWorkerCount is the number of background workers consuming and sending events
 	DefaultWorkerCount = 1
 
+	// DefaultCompressionLevel is the compression level used when compression is enabled
+	DefaultCompressionLevel = flate.DefaultCompression
+
 	// DefaultInsertRequestTimeout is the amount of seconds to wait for a insert response
 	DefaultInsertRequestTimeout = 10 * time.Second
 	// DefaultQueryRequestTimeout is the amount of seconds to wait for a query response
 	DefaultQueryRequestTimeout = 20 * time.Second
 
+	// DefaultMaxIdleConns is the number of idle connections kept open by the default HTTP client
+	DefaultMaxIdleConns = 100
+	// DefaultMaxIdleConnsPerHost is the number of idle connections kept open to each host
+	DefaultMaxIdleConnsPerHost = 10
+	// DefaultIdleConnTimeout is how long idle connections are kept open
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultSpoolMaxBytes is the maximum size of the on-disk spool
+	DefaultSpoolMaxBytes = 100 * 1024 * 1024
+	// DefaultSpoolReplayInterval is how often spooled batches are resent
+	DefaultSpoolReplayInterval = 30 * time.Second
+
+	// DefaultCircuitBreakerCooldown is how long the circuit breaker stays open before probing
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+
 	// DefaultRetries is how many times to attempt the query
 	DefaultRetries = 3
 	// DefaultRetryWaitTime is the amount of seconds between query attempts
@@ -35,7 +65,9 @@ const (
 // Compression to use during transport.
 type Compression int32
 
-// Supported / recognized types of compression
+// Supported / recognized types of compression. Deflate and Zlib both send a
+// zlib wrapped DEFLATE stream with a "Content-Encoding: deflate" header, as
+// that is what the deflate content coding means in HTTP (RFC 9110).
 const (
 	None    Compression = iota
 	Deflate Compression = iota
@@ -43,6 +75,67 @@ const (
 	Zlib    Compression = iota
 )
 
+func (c Compression) String() string {
+	switch c {
+	case None:
+		return "None"
+	case Deflate:
+		return "Deflate"
+	case Gzip:
+		return "Gzip"
+	case Zlib:
+		return "Zlib"
+	default:
+		return fmt.Sprintf("Compression(%d)", int32(c))
+	}
+}
+
+// contentEncoding is the value of the Content-Encoding header for c
+func (c Compression) contentEncoding() string {
+	switch c {
+	case Deflate, Zlib:
+		return "deflate"
+	case Gzip:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// QueueFullPolicy decides what EnqueueEvent does when the event queue is full
+type QueueFullPolicy int32
+
+// Supported queue full policies
+const (
+	// QueueBlock waits for room in the queue, or for the context to be done
+	QueueBlock QueueFullPolicy = iota
+	// QueueDropNewest drops the event being enqueued
+	QueueDropNewest
+	// QueueDropOldest drops the oldest queued event to make room
+	QueueDropOldest
+	// QueueSpillToDisk writes the event to the spool, to be sent later by
+	// the replay worker. Spilled events are written in batches by a
+	// background worker. It requires SpoolDir, and drops the event if it
+	// can't be spooled, or if the worker is still busy with BatchSize
+	// events.
+	QueueSpillToDisk
+)
+
+func (p QueueFullPolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "Block"
+	case QueueDropNewest:
+		return "DropNewest"
+	case QueueDropOldest:
+		return "DropOldest"
+	case QueueSpillToDisk:
+		return "SpillToDisk"
+	default:
+		return fmt.Sprintf("QueueFullPolicy(%d)", int32(p))
+	}
+}
+
 // Client is the building block of the insert and query clients
 type Client struct {
 	URL            *url.URL
@@ -50,23 +143,168 @@ type Client struct {
 	RequestTimeout time.Duration
 	RetryCount     int
 	RetryWait      time.Duration
+	// RetryPolicy decides when failed requests are retried. When nil,
+	// retryable errors are attempted RetryCount times, RetryWait apart.
+	RetryPolicy RetryPolicy
+	// HTTPClient sends the requests. When nil, an http.Client with pooled
+	// connections shared by every client is used.
+	HTTPClient *http.Client
+	// Middleware wraps the transport of HTTPClient, the first one being
+	// the outermost. It must be set before the first request is sent.
+	Middleware []Middleware
+
+	accountID         string
+	httpClientOnce    sync.Once
+	wrappedHTTPClient *http.Client
 }
 
 // InsertClient contains all of the configuration required for inserts
 type InsertClient struct {
 	InsertKey   string
 	eventQueue  chan []byte
+	batchQueue  chan *queuedBatch
 	eventTimer  *time.Timer
-	flushQueue  chan bool
+	flushQueue  chan *flushRequest
 	WorkerCount int
 	BatchSize   int
 	BatchTime   time.Duration
 	Compression Compression
+	// CompressionLevel is passed to the compressor, from
+	// flate.HuffmanOnly to flate.BestCompression
+	CompressionLevel int
+	// MinCompressionBytes is the size under which request bodies are sent
+	// uncompressed, as compressing them is not worth the effort
+	MinCompressionBytes int
+	// MaxBatchBytes is the largest request body to send, after compression.
+	// As the compressed size is only known once a batch is sent, it is
+	// estimated from how well the previous batch compressed. Batches are
+	// cut before they are expected to exceed it, and events expected not
+	// to fit in a batch on their own are rejected by EnqueueEvent. Zero
+	// means no limit.
+	MaxBatchBytes int
+	// ValidationMode decides what happens to events breaking the Insights
+	// rules, in both PostEvent and batch mode
+	ValidationMode ValidationMode
+	// OnError, when set, receives the errors encountered in batch mode
+	// instead of having them logged. Batches that could not be sent are
+	// reported as a *BatchError. It is called from background goroutines,
+	// so it must be safe for concurrent use and should not block.
+	OnError func(error)
+	// SpoolDir, when set, enables persisting batches that could not be sent
+	// to this directory. They are resent in the background once Insights is
+	// reachable again, including after a restart.
+	SpoolDir string
+	// SpoolMaxBytes caps the size of the spool, the oldest batches are
+	// evicted to make room for new ones. DefaultSpoolMaxBytes is used if
+	// it is not positive.
+	SpoolMaxBytes int64
+	// SpoolReplayInterval is how often spooled batches are resent.
+	// DefaultSpoolReplayInterval is used if it is not positive.
+	SpoolReplayInterval time.Duration
+	// MaxBufferedBytes caps the memory used by events, from the time they
+	// are enqueued until they have been sent, including retries. Events are
+	// let in one at a time while nothing is buffered, so a single event may
+	// go over it. Zero means no limit.
+	MaxBufferedBytes int64
+	// CommonAttributes are added to every event, in both PostEvent and
+	// batch mode, unless the event has an attribute of the same name. It
+	// must not be modified once events are sent.
+	CommonAttributes map[string]interface{}
+	// MetadataProviders describe where events come from. They are called
+	// once, and their attributes are added like CommonAttributes, which
+	// take precedence over them. See DefaultMetadataProviders.
+	MetadataProviders []MetadataProvider
+	// CommonAttributesFunc, when set, is called for every event and its
+	// attributes are added like CommonAttributes, taking precedence over
+	// them. It must be safe for concurrent use.
+	CommonAttributesFunc func() map[string]interface{}
+	// Sampling maps eventTypes to the SamplingRule EnqueueEvent applies to
+	// them. Events of other types are all kept.
+	Sampling map[string]SamplingRule
+	// QueueFullPolicy decides what EnqueueEvent does when the queue is full
+	// or MaxBufferedBytes is reached
+	QueueFullPolicy QueueFullPolicy
+	// OnDrop, when set, is called with every event dropped because the
+	// queue is full. It is called from EnqueueEvent, so it should not block.
+	OnDrop func(event []byte)
+	// SelfTelemetryInterval, when set, makes the client enqueue a
+	// SelfTelemetryEventType event describing its own health at this
+	// interval in batch mode: the changes in its Statistics, its queue
+	// depth, send latency and HTTP errors. Zero disables it.
+	SelfTelemetryInterval time.Duration
+	// MaxRequestsPerSecond limits the insert requests sent, including
+	// retries and PostEvent. Requests over the limit wait for their turn
+	// instead of failing. Zero means no limit.
+	MaxRequestsPerSecond float64
+	// MaxEventsPerMinute limits the events sent per minute, in bursts of up
+	// to a minute's worth. Batches over the limit wait for their turn. Zero
+	// means no limit.
+	MaxEventsPerMinute int
+	// MaxEventsPerMinuteByType limits the events of each eventType sent per
+	// minute, like MaxEventsPerMinute
+	MaxEventsPerMinuteByType map[string]int
+	// CircuitBreakerThreshold, when set, opens the circuit breaker after
+	// this many consecutive insert requests fail with a server or network
+	// error. While it is open, requests fail with ErrCircuitOpen without
+	// being retried, and batches are spooled if SpoolDir is set. After
+	// CircuitBreakerCooldown, a single probe request is let through, which
+	// closes the circuit if it succeeds. Zero disables it.
+	CircuitBreakerThreshold int
+	// CircuitBreakerCooldown is how long the circuit breaker stays open
+	// before sending a probe request
+	CircuitBreakerCooldown time.Duration
+	// OnCircuitStateChange, when set, is called with every change of the
+	// circuit breaker state. It is called while sending, so it should not
+	// block.
+	OnCircuitStateChange func(from, to CircuitState)
 	Client
 	Statistics
+
+	spool *spool
+
+	lastCompressionRatio uint64 // float64 bits, accessed atomically
+
+	sendMetrics sendMetrics
+
+	limiterOnce sync.Once
+	limiter     *rateLimiter
+
+	breakerOnce sync.Once
+	breaker     *circuitBreaker
+
+	ratesMu   sync.Mutex
+	ratesPrev Statistics
+	ratesAt   time.Time
+
+	sampler sampler
+
+	commonOnce    sync.Once
+	commonEncoded []encodedAttribute
+	commonErr     error
+
+	pendingMu      sync.Mutex
+	pendingBatches map[*queuedBatch]struct{} // handed to the senders and not yet done
+
+	bufferMu    sync.Mutex
+	bufferFreed chan struct{} // closed when buffered bytes are released
+
+	// lifecycle state for batch mode, guarded by stateMu
+	stateMu      sync.RWMutex
+	closed       bool
+	quit         chan struct{} // closed when Close is called
+	stop         chan struct{} // closed once no more events can be queued
+	stopped      chan struct{} // closed once all background work has finished
+	spillQueue   chan []byte   // events waiting to be spilled, with QueueSpillToDisk
+	cancelSends  func()        // abandons the retries of the senders
+	shutdownErrs []error
+
+	enqueuers sync.WaitGroup // callers currently inside EnqueueEventContext
+	workers   sync.WaitGroup // watchdog, batchWorker, queueWorker, replayWorker and spillWorker goroutines
+	senders   sync.WaitGroup // sendWorker goroutines
 }
 
-// Statistics about the inserted data
+// Statistics about the inserted data. The fields are updated atomically
+// while the client runs; use InsertClient.Snapshot to read them.
 type Statistics struct {
 	// the number of events added using EnqueueEvent
 	EventCount int64
@@ -84,8 +322,39 @@ type Statistics struct {
 	TimerExpiredCount int64
 	// the number of times failed batches have been retried
 	InsightsRetryCount int64
-	// not used
+	// the number of requests to Insights that failed, see HTTPErrors for their causes
 	HTTPErrorCount int64
+	// the number of failed batches written to the spool
+	SpooledBatchCount int64
+	// the number of spooled batches that were successfully resent
+	ReplayedBatchCount int64
+	// the number of spooled batches discarded to stay within SpoolMaxBytes
+	SpoolEvictedCount int64
+	// the number of batches currently being sent (including retries)
+	InFlightBatchCount int64
+	// the number of batches waiting for a free worker
+	WaitingBatchCount int64
+	// the number of times a batch was split after being rejected as too large
+	SplitBatchCount int64
+	// the number of bytes of events currently queued, batched or being sent
+	BufferedBytes int64
+	// the number of events discarded by Sampling
+	SampledOutEventCount int64
+	// the number of events dropped because the queue was full
+	DroppedEventCount int64
+	// the number of events written to the spool because the queue was full
+	SpilledEventCount int64
+	// the number of requests delayed by the rate limits
+	RateLimitedCount int64
+	// the total time, in nanoseconds, requests waited for the rate limits
+	RateLimitWaitTime int64
+	// the current CircuitState of the circuit breaker, as returned by
+	// InsertClient.CircuitState
+	CircuitStateValue int64
+	// the number of times the circuit breaker opened
+	CircuitOpenCount int64
+	// the number of requests failed with ErrCircuitOpen
+	CircuitRejectedCount int64
 }
 
 // Assumption here that responses from insights are either success or error.
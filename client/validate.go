@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxEventAttributes is the maximum number of attributes in a single event
+	MaxEventAttributes = 255
+	// MaxAttributeNameLength is the maximum length of an attribute name, in bytes
+	MaxAttributeNameLength = 255
+	// MaxEventTypeLength is the maximum length of an eventType, in bytes
+	MaxEventTypeLength = 255
+	// MaxStringValueLength is the maximum length of a string attribute value, in bytes
+	MaxStringValueLength = 4096
+)
+
+// ValidationMode controls what happens to events breaking the Insights rules
+type ValidationMode int32
+
+// Supported validation modes
+const (
+	// ValidationReject refuses events breaking any rule
+	ValidationReject ValidationMode = iota
+	// ValidationFix repairs events where possible: long strings are
+	// truncated, nested values are turned into JSON strings, and attributes
+	// that can't be repaired are dropped. Events without a valid
+	// eventType can't be repaired and are refused.
+	ValidationFix
+	// ValidationWarn logs every violation and sends events unchanged
+	ValidationWarn
+)
+
+// Rules checked by the event validation
+const (
+	RuleEventType       = "eventType"
+	RuleReservedName    = "reservedName"
+	RuleTooManyAttrs    = "tooManyAttributes"
+	RuleNameLength      = "nameLength"
+	RuleValueLength     = "valueLength"
+	RuleNestedValue     = "nestedValue"
+	RuleUnsupportedType = "unsupportedType"
+)
+
+var (
+	eventTypePattern = regexp.MustCompile(`^[a-zA-Z0-9_:]+$`)
+
+	// Attributes set by Insights itself
+	reservedAttributes = map[string]bool{
+		"accountId": true,
+		"appId":     true,
+	}
+
+	// NRQL keywords can't be used as eventType
+	nrqlReservedWords = map[string]bool{
+		"ago": true, "and": true, "as": true, "auto": true, "begin": true, "begintime": true,
+		"compare": true, "day": true, "days": true, "end": true, "endtime": true, "explain": true,
+		"facet": true, "from": true, "hour": true, "hours": true, "in": true, "is": true,
+		"like": true, "limit": true, "minute": true, "minutes": true, "month": true, "months": true,
+		"not": true, "null": true, "offset": true, "or": true, "raw": true, "second": true,
+		"seconds": true, "select": true, "since": true, "timeseries": true, "until": true,
+		"week": true, "weeks": true, "where": true, "with": true,
+	}
+)
+
+// Violation is a single way an event breaks the Insights rules
+type Violation struct {
+	// Event is the index of the event in a JSON array, 0 for a single event
+	Event int
+	// Attribute is the name of the offending attribute
+	Attribute string
+	// Rule is the Rule* constant that was broken
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Attribute == "" {
+		return fmt.Sprintf("event %d: %s", v.Event, v.Message)
+	}
+	return fmt.Sprintf("event %d: %s: %s", v.Event, v.Attribute, v.Message)
+}
+
+// ValidationError lists every violation found in the events
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i := range e.Violations {
+		msgs[i] = e.Violations[i].String()
+	}
+	return fmt.Sprintf("invalid insights event: %s", strings.Join(msgs, "; "))
+}
+
+// ValidateEvent checks a marshalled event, or a JSON array of events,
+// against the Insights rules, returning a *ValidationError listing every
+// violation found.
+func ValidateEvent(data []byte) error {
+	_, err := validateEvents(data, ValidationReject)
+	return err
+}
+
+// validateEvent applies the client's ValidationMode to a marshalled event or
+// array of events, returning the data to send.
+func (c *InsertClient) validateEvent(data []byte) ([]byte, error) {
+	fixed, err := validateEvents(data, c.ValidationMode)
+	if err != nil && c.ValidationMode == ValidationWarn {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			c.Logger.Warn(err)
+			return data, nil
+		}
+	}
+
+	return fixed, err
+}
+
+// validateEvents checks data against the Insights rules. In ValidationFix
+// mode the repaired events are returned, otherwise data is returned as is.
+func validateEvents(data []byte, mode ValidationMode) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	isArray := len(trimmed) > 0 && trimmed[0] == '['
+
+	var events []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	if isArray {
+		if err := decoder.Decode(&events); err != nil {
+			return nil, fmt.Errorf("event data is not a JSON array of objects: %w", err)
+		}
+	} else {
+		var event map[string]interface{}
+		if err := decoder.Decode(&event); err != nil || event == nil {
+			return nil, fmt.Errorf("event data is not a JSON object: %s", data)
+		}
+		events = append(events, event)
+	}
+
+	var violations []Violation
+	for i := range events {
+		violations = append(violations, validateAttributes(i, events[i], mode == ValidationFix)...)
+	}
+
+	if mode != ValidationFix {
+		if len(violations) > 0 {
+			return data, &ValidationError{Violations: violations}
+		}
+		return data, nil
+	}
+
+	// Only a bad eventType can't be fixed
+	var unfixable []Violation
+	for _, v := range violations {
+		if v.Rule == RuleEventType {
+			unfixable = append(unfixable, v)
+		}
+	}
+	if len(unfixable) > 0 {
+		return nil, &ValidationError{Violations: unfixable}
+	}
+	if len(violations) == 0 {
+		return data, nil
+	}
+
+	if isArray {
+		return json.Marshal(events)
+	}
+	return json.Marshal(events[0])
+}
+
+// validateAttributes checks a single decoded event, repairing it in place
+// if fix is set.
+func validateAttributes(index int, event map[string]interface{}, fix bool) []Violation {
+	var violations []Violation
+	violate := func(attr, rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Event: index, Attribute: attr, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if eventType, ok := event["eventType"].(string); !ok {
+		violate("eventType", RuleEventType, "eventType is required and must be a string")
+	} else if msg := checkEventType(eventType); msg != "" {
+		violate("eventType", RuleEventType, "%s", msg)
+	}
+
+	// Sorted, so fixing too many attributes is deterministic
+	names := make([]string, 0, len(event))
+	for name := range event {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "eventType" {
+			continue
+		}
+
+		value := event[name]
+		if rule, msg := checkAttributeName(name); rule != "" {
+			violate(name, rule, "%s", msg)
+			if fix {
+				delete(event, name)
+			}
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			if len(v) > MaxStringValueLength {
+				violate(name, RuleValueLength, "value is %d bytes, longer than %d", len(v), MaxStringValueLength)
+				if fix {
+					event[name] = truncateString(v, MaxStringValueLength)
+				}
+			}
+		case json.Number:
+			if f, err := v.Float64(); err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+				violate(name, RuleUnsupportedType, "value %s is not a supported number", v)
+				if fix {
+					delete(event, name)
+				}
+			}
+		case map[string]interface{}, []interface{}:
+			violate(name, RuleNestedValue, "nested objects and arrays are not supported")
+			if fix {
+				nested, _ := json.Marshal(v)
+				event[name] = truncateString(string(nested), MaxStringValueLength)
+			}
+		case nil:
+			// Insights ignores null attributes, such as nil pointers and
+			// maps holding nil, so they are not a violation. They are
+			// left out of repaired events.
+			delete(event, name)
+		}
+	}
+
+	if len(event) > MaxEventAttributes {
+		violate("", RuleTooManyAttrs, "event has %d attributes, more than %d", len(event), MaxEventAttributes)
+		if fix {
+			kept := 1 // eventType
+			for _, name := range names {
+				if _, ok := event[name]; !ok || name == "eventType" {
+					continue
+				}
+				if kept >= MaxEventAttributes {
+					delete(event, name)
+					continue
+				}
+				kept++
+			}
+		}
+	}
+
+	return violations
+}
+
+// checkEventType returns why eventType is not valid, or "" if it is
+func checkEventType(eventType string) string {
+	switch {
+	case len(eventType) == 0 || len(eventType) > MaxEventTypeLength:
+		return fmt.Sprintf("eventType must be between 1 and %d bytes long", MaxEventTypeLength)
+	case !eventTypePattern.MatchString(eventType):
+		return fmt.Sprintf("eventType %q may only contain letters, numbers, '_' and ':'", eventType)
+	case nrqlReservedWords[strings.ToLower(eventType)]:
+		return fmt.Sprintf("eventType %q is a reserved word", eventType)
+	}
+	return ""
+}
+
+// checkAttributeName returns the rule broken by an attribute name and why,
+// or "" if the name is valid.
+func checkAttributeName(name string) (string, string) {
+	switch {
+	case reservedAttributes[name]:
+		return RuleReservedName, fmt.Sprintf("%s is a reserved attribute name", name)
+	case len(name) > MaxAttributeNameLength:
+		return RuleNameLength, fmt.Sprintf("attribute name is longer than %d bytes", MaxAttributeNameLength)
+	}
+	return "", ""
+}
+
+// truncateString shortens s to at most max bytes without splitting a rune
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	s = s[:max]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+//go:build unit
+// +build unit
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func violatedRules(err error) []string {
+	var rules []string
+	if validationErr, ok := err.(*ValidationError); ok {
+		for _, v := range validationErr.Violations {
+			rules = append(rules, v.Rule)
+		}
+	}
+	return rules
+}
+
+func TestValidateEvent_good(t *testing.T) {
+	assert.NoError(t, ValidateEvent([]byte(testInsertJSONString)))
+	assert.NoError(t, ValidateEvent([]byte(`[{"eventType":"a:b_c","num":1.5,"ok":true},{"eventType":"d"}]`)))
+}
+
+func TestValidateEvent_notJSON(t *testing.T) {
+	err := ValidateEvent(testInsertJSONBad)
+	assert.Error(t, err)
+	assert.Nil(t, violatedRules(err), "Malformed JSON is not a violation")
+
+	assert.Error(t, ValidateEvent([]byte(`[1, 2]`)))
+	assert.Error(t, ValidateEvent([]byte(`null`)))
+}
+
+func TestValidateEvent_violations(t *testing.T) {
+	tests := map[string]string{
+		`{"foo":"eventType"}`:                                            RuleEventType,
+		`{"eventType":42}`:                                               RuleEventType,
+		`{"eventType":"has space"}`:                                      RuleEventType,
+		`{"eventType":"Select"}`:                                         RuleEventType,
+		`{"eventType":"` + strings.Repeat("a", 256) + `"}`:               RuleEventType,
+		`{"eventType":"test","appId":1}`:                                 RuleReservedName,
+		`{"eventType":"test","` + strings.Repeat("a", 256) + `":1}`:      RuleNameLength,
+		`{"eventType":"test","str":"` + strings.Repeat("a", 4097) + `"}`: RuleValueLength,
+		`{"eventType":"test","obj":{"a":1}}`:                             RuleNestedValue,
+		`{"eventType":"test","list":[1,2]}`:                              RuleNestedValue,
+		`{"eventType":"test","huge":1e400}`:                              RuleUnsupportedType,
+	}
+
+	for event, rule := range tests {
+		err := ValidateEvent([]byte(event))
+		assert.Equal(t, []string{rule}, violatedRules(err), "Wrong violation for %.60s", event)
+	}
+}
+
+func TestValidateEvent_null(t *testing.T) {
+	data := []byte(`{"eventType":"test","nothing":null}`)
+	assert.NoError(t, ValidateEvent(data), "Null attributes are ignored by Insights")
+
+	client := NewInsertClient(testKey, testID)
+	validated, err := client.validateEvent(data)
+	assert.NoError(t, err, "Null attributes should not be rejected in the default mode")
+	assert.Equal(t, data, validated)
+}
+
+func TestValidateEvent_tooManyAttributes(t *testing.T) {
+	event := map[string]interface{}{"eventType": "test"}
+	for i := 0; i < MaxEventAttributes; i++ {
+		event[fmt.Sprintf("attr%03d", i)] = i
+	}
+	data, err := json.Marshal(event)
+	assert.NoError(t, err)
+
+	err = ValidateEvent(data)
+	assert.Equal(t, []string{RuleTooManyAttrs}, violatedRules(err))
+
+	fixed, err := validateEvents(data, ValidationFix)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateEvent(fixed))
+
+	var fixedEvent map[string]interface{}
+	assert.NoError(t, json.Unmarshal(fixed, &fixedEvent))
+	assert.Equal(t, MaxEventAttributes, len(fixedEvent))
+	assert.Equal(t, "test", fixedEvent["eventType"])
+}
+
+func TestValidateEvent_reportsEverything(t *testing.T) {
+	err := ValidateEvent([]byte(`[{"eventType":"test","appId":1},{"obj":{}}]`))
+
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(validationErr.Violations))
+	assert.Equal(t, 1, validationErr.Violations[1].Event)
+	assert.Contains(t, err.Error(), "appId")
+}
+
+func TestValidateEvents_fix(t *testing.T) {
+	long := strings.Repeat("é", MaxStringValueLength) // 2 bytes per rune
+	data := []byte(`{"eventType":"test","str":"` + long + `","obj":{"a":1},"nothing":null,"accountId":1,"num":1}`)
+
+	fixed, err := validateEvents(data, ValidationFix)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateEvent(fixed))
+
+	var event map[string]interface{}
+	assert.NoError(t, json.Unmarshal(fixed, &event))
+	assert.Equal(t, MaxStringValueLength, len(event["str"].(string)))
+	assert.Equal(t, `{"a":1}`, event["obj"])
+	assert.Equal(t, float64(1), event["num"])
+	assert.NotContains(t, event, "nothing")
+	assert.NotContains(t, event, "accountId")
+
+	// Can't make up an eventType
+	_, err = validateEvents([]byte(`{"num":1}`), ValidationFix)
+	assert.Equal(t, []string{RuleEventType}, violatedRules(err))
+
+	// Nothing to fix
+	fixed, err = validateEvents([]byte(testInsertJSONString), ValidationFix)
+	assert.NoError(t, err)
+	assert.Equal(t, testInsertJSONString, string(fixed))
+}
@@ -18,6 +18,7 @@ var (
 	insightsKey = kingpin.Flag("key", "Your insights key.").Short('k').Required().String()
 	accountID   = kingpin.Flag("id", "Your New Relic account ID").Short('i').Required().String()
 	insightsURL = kingpin.Flag("url", "Custom insights endpoint.").Short('u').String()
+	region      = kingpin.Flag("region", "Region of your New Relic account: US, EU or FedRAMP.").Short('r').Default("US").String()
 
 	insertCmd = kingpin.Command("insert", "Insert data to insights.")
 	dataFile  = insertCmd.Arg("file path", "Path to file containing data to insert.").Required().String()
@@ -39,6 +40,11 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	insightsRegion, regionErr := client.ParseRegion(*region)
+	if regionErr != nil {
+		log.Fatal(regionErr)
+	}
+
 	log.Debugf("Creating new %s client", cmd)
 
 	switch cmd {
@@ -47,8 +53,13 @@ func main() {
 		if cli == nil {
 			log.Fatalf("Failed to create a %s client", cmd)
 		}
+		if err := cli.SetRegion(insightsRegion); err != nil {
+			log.Fatal(err)
+		}
 		if len(*insightsURL) > 0 {
-			cli.UseCustomURL(*insightsURL)
+			if err := cli.UseCustomURL(*insightsURL); err != nil {
+				log.Fatal(err)
+			}
 		}
 
 		if err := cli.Validate(); err != nil {
@@ -65,8 +76,13 @@ func main() {
 
 	case "query":
 		cli := client.NewQueryClient(*insightsKey, *accountID)
+		if err := cli.SetRegion(insightsRegion); err != nil {
+			log.Fatal(err)
+		}
 		if len(*insightsURL) > 0 {
-			cli.UseCustomURL(*insightsURL)
+			if err := cli.UseCustomURL(*insightsURL); err != nil {
+				log.Fatal(err)
+			}
 		}
 
 		if err := cli.Validate(); err != nil {